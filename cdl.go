@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/akamensky/argparse"
+	"github.com/is386/GoBoy/emu/cdl"
+)
+
+func runCDL(args []string) {
+	parser := argparse.NewParser("GameFella cdl", "Merges code/data logs and prints their ROM coverage.")
+
+	filesFlag := parser.StringList("f", "file",
+		&argparse.Options{
+			Required: true,
+			Help:     "Code/data log to read, may be repeated",
+		})
+
+	outFlag := parser.String("o", "out",
+		&argparse.Options{
+			Required: false,
+			Help:     "Write the merged log to this file",
+			Default:  "",
+		})
+
+	err := parser.Parse(args)
+	if err != nil {
+		fmt.Print(parser.Usage(err))
+		os.Exit(0)
+	}
+
+	var merged *cdl.Log
+	for _, filename := range *filesFlag {
+		log, err := cdl.Load(filename)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(0)
+		}
+		if merged == nil {
+			merged = log
+		} else if err := merged.Merge(log); err != nil {
+			fmt.Printf("%s: %s\n", filename, err)
+			os.Exit(0)
+		}
+	}
+
+	merged.WriteSummary(os.Stdout)
+	if *outFlag != "" {
+		if err := merged.Save(*outFlag); err != nil {
+			fmt.Println(err)
+			os.Exit(0)
+		}
+	}
+}
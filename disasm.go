@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/akamensky/argparse"
+	"github.com/is386/GoBoy/emu/disasm"
+	"github.com/is386/GoBoy/emu/symbols"
+)
+
+func runDisasm(args []string) {
+	parser := argparse.NewParser("GameFella disasm", "Disassembles the banks of a ROM.")
+
+	romFlag := parser.String("r", "rom",
+		&argparse.Options{
+			Required: true,
+			Help:     "Path to ROM",
+		})
+
+	bankFlag := parser.Int("b", "bank",
+		&argparse.Options{
+			Required: false,
+			Help:     "ROM bank to disassemble, -1 for all banks",
+			Default:  -1,
+		})
+
+	symFlag := parser.String("", "sym",
+		&argparse.Options{
+			Required: false,
+			Help:     "Path to an RGBDS .sym file, found next to the ROM by default",
+			Default:  "",
+		})
+
+	err := parser.Parse(args)
+	if err != nil {
+		fmt.Print(parser.Usage(err))
+		os.Exit(0)
+	}
+
+	rom, err := ioutil.ReadFile(*romFlag)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(0)
+	}
+
+	var syms *symbols.Table
+	if *symFlag != "" {
+		syms, err = symbols.Load(*symFlag)
+	} else {
+		syms, _, err = symbols.Discover(*romFlag)
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(0)
+	}
+
+	first, last := *bankFlag, *bankFlag
+	if *bankFlag < 0 {
+		first, last = 0, (len(rom)-1)/0x4000
+	}
+	for bank := first; bank <= last; bank++ {
+		labels := func(addr uint16) string {
+			if addr >= 0x4000 && addr < 0x8000 {
+				return syms.Lookup(bank, addr)
+			}
+			return syms.Lookup(0, addr)
+		}
+		for _, instr := range disasm.DisassembleBank(rom, bank, labels) {
+			if instr.Label != "" {
+				fmt.Printf("%s:\n", instr.Label)
+			}
+			fmt.Printf("%02X:%s\n", bank, instr)
+		}
+	}
+}
@@ -1,10 +1,8 @@
 package apu
 
 import (
-	"time"
-
-	"github.com/hajimehoshi/oto"
 	"github.com/is386/GoBoy/emu/bits"
+	"github.com/is386/GoBoy/emu/state"
 )
 
 var (
@@ -30,11 +28,8 @@ var (
 	NR51 uint8 = 0x25
 	NR52 uint8 = 0x26
 
-	FPS         = 120
 	SAMPLE_RATE = 44100
-	SAMPLES     = 8192
 	CLOCK_SPEED = 4194304
-	FRAMETIME   = time.Second / time.Duration(FPS)
 	CYCLES      = 8192
 )
 
@@ -46,8 +41,7 @@ type APU struct {
 	cyc           int
 	frameSequence int
 	sampleCounter int
-	player        *oto.Player
-	buffer        chan [2]uint8
+	samples       []uint8
 	volLeft       uint8
 	volRight      uint8
 }
@@ -58,49 +52,41 @@ func NewAPU() *APU {
 	apu.c2 = NewChannel2()
 	apu.c3 = NewChannel3()
 	apu.c4 = NewChannel4()
-	apu.buffer = make(chan [2]uint8, SAMPLES)
-
-	ctx, err := oto.NewContext(SAMPLE_RATE, 2, 1, SAMPLE_RATE/FPS)
-	if err != nil {
-		panic(err)
-	}
-
-	apu.player = ctx.NewPlayer()
-	apu.startSoundRoutine()
 	return apu
 }
 
-func (a *APU) startSoundRoutine() {
-	ticker := time.NewTicker(FRAMETIME)
-	go func() {
-		var reading [2]byte
-		for range ticker.C {
-			fbLen := len(a.buffer)
-			buffer := make([]byte, fbLen*2)
-			for i := 0; i < fbLen*2; i += 2 {
-				reading = <-a.buffer
-				if reading[0] == 0 && reading[1] == 0 {
-					continue
-				}
-				buffer[i], buffer[i+1] = reading[0], reading[1]
-			}
-			a.player.Write(buffer)
-		}
-	}()
+func (a *APU) Serialize(s state.Stream) {
+	s.Int(&a.cyc)
+	s.Int(&a.frameSequence)
+	s.Int(&a.sampleCounter)
+	s.Uint8(&a.volLeft)
+	s.Uint8(&a.volRight)
+	a.c1.serialize(s)
+	a.c2.serialize(s)
+	a.c3.serialize(s)
+	a.c4.serialize(s)
+}
+
+// Flush returns the interleaved stereo samples generated since the last
+// call. The returned slice is only valid until the next Update.
+func (a *APU) Flush() []uint8 {
+	samples := a.samples
+	a.samples = a.samples[:0]
+	return samples
 }
 
+// Update runs the APU for cyc clocks. The channels are stepped once for all
+// of them, since a tick is much shorter than a sample.
 func (a *APU) Update(cyc int) {
-	for i := 0; i < cyc; i++ {
-		a.frameSequencer()
-		a.updateChannels()
-		a.playSound()
-	}
+	a.frameSequencer(cyc)
+	a.updateChannels(cyc)
+	a.playSound(cyc)
 }
 
-func (a *APU) frameSequencer() {
-	a.cyc--
+func (a *APU) frameSequencer(cyc int) {
+	a.cyc -= cyc
 	if a.cyc <= 0 {
-		a.cyc = CYCLES
+		a.cyc += CYCLES
 		switch a.frameSequence {
 		case 0:
 			a.c1.clockLength()
@@ -134,15 +120,15 @@ func (a *APU) frameSequencer() {
 	}
 }
 
-func (a *APU) updateChannels() {
-	a.c1.update()
-	a.c2.update()
-	a.c3.update()
-	a.c4.update()
+func (a *APU) updateChannels(cyc int) {
+	a.c1.update(cyc)
+	a.c2.update(cyc)
+	a.c3.update(cyc)
+	a.c4.update(cyc)
 }
 
-func (a *APU) playSound() {
-	a.sampleCounter += SAMPLE_RATE
+func (a *APU) playSound(cyc int) {
+	a.sampleCounter += SAMPLE_RATE * cyc
 	if a.sampleCounter >= CLOCK_SPEED {
 		a.sampleCounter -= CLOCK_SPEED
 
@@ -152,7 +138,7 @@ func (a *APU) playSound() {
 		l := uint8(sampleL * int(a.volLeft/2))
 		r := uint8(sampleR * int(a.volRight/2))
 
-		a.buffer <- [2]uint8{l, r}
+		a.samples = append(a.samples, l, r)
 	}
 }
 
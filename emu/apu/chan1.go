@@ -2,6 +2,7 @@ package apu
 
 import (
 	"github.com/is386/GoBoy/emu/bits"
+	"github.com/is386/GoBoy/emu/state"
 )
 
 type Channel1 struct {
@@ -41,12 +42,40 @@ func NewChannel1() *Channel1 {
 	return &Channel1{}
 }
 
-func (c *Channel1) update() {
+func (c *Channel1) serialize(s state.Stream) {
+	s.Uint16(&c.freqLowBits)
+	s.Uint16(&c.freqHighBits)
+	s.Int(&c.freqTimer)
+	s.Uint8(&c.duty)
+	s.Uint8(&c.dutyPosition)
+	s.Uint8(&c.envVol)
+	s.Uint8(&c.envPeriod)
+	s.Uint8(&c.envDir)
+	s.Int(&c.envTimer)
+	s.Int(&c.currVol)
+	s.Uint8(&c.sweepPeriod)
+	s.Uint8(&c.sweepDir)
+	s.Uint8(&c.sweepShift)
+	s.Int(&c.sweepTimer)
+	s.Bool(&c.sweepEnabled)
+	s.Int(&c.shadowFreq)
+	s.Uint8(&c.triggerBit)
+	s.Uint8(&c.length)
+	s.Int(&c.lengthTimer)
+	s.Uint8(&c.lengthEnabled)
+	s.Uint8(&c.leftOn)
+	s.Uint8(&c.rightOn)
+	s.Int(&c.left)
+	s.Int(&c.right)
+	s.Bool(&c.enabled)
+}
+
+func (c *Channel1) update(cyc int) {
 	var sample int
-	c.freqTimer--
-	if c.freqTimer <= 0 {
+	c.freqTimer -= cyc
+	for c.freqTimer <= 0 {
 		freq := int((uint16(c.freqHighBits) << 8) | uint16(c.freqLowBits))
-		c.freqTimer = (2048 - freq) * 4
+		c.freqTimer += (2048 - freq) * 4
 		c.dutyPosition += 1
 		c.dutyPosition &= 7
 
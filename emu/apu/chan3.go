@@ -2,10 +2,11 @@ package apu
 
 import (
 	"github.com/is386/GoBoy/emu/bits"
+	"github.com/is386/GoBoy/emu/state"
 )
 
 var (
-	OUTPUT_LEVELS = map[uint8]uint8{
+	OUTPUT_LEVELS = [4]uint8{
 		0x0: 4,
 		0x1: 0,
 		0x2: 1,
@@ -41,12 +42,32 @@ func NewChannel3() *Channel3 {
 	return &Channel3{}
 }
 
-func (c *Channel3) update() {
+func (c *Channel3) serialize(s state.Stream) {
+	s.Bytes(c.waveRAM[:])
+	s.Int(&c.wavePosition)
+	s.Uint8(&c.freqLowBits)
+	s.Uint8(&c.freqHighBits)
+	s.Int(&c.freqTimer)
+	s.Uint8(&c.outputLevelByte)
+	s.Uint8(&c.outputLevel)
+	s.Uint8(&c.enableByte)
+	s.Uint8(&c.triggerBit)
+	s.Uint8(&c.length)
+	s.Int(&c.lengthTimer)
+	s.Uint8(&c.lengthEnabled)
+	s.Uint8(&c.leftOn)
+	s.Uint8(&c.rightOn)
+	s.Int(&c.left)
+	s.Int(&c.right)
+	s.Bool(&c.enabled)
+}
+
+func (c *Channel3) update(cyc int) {
 	var sample int
-	c.freqTimer--
-	if c.freqTimer <= 0 {
+	c.freqTimer -= cyc
+	for c.freqTimer <= 0 {
 		freq := int((uint16(c.freqHighBits) << 8) | uint16(c.freqLowBits))
-		c.freqTimer = (2048 - freq) * 2
+		c.freqTimer += (2048 - freq) * 2
 		c.wavePosition++
 		c.wavePosition &= 31
 
@@ -2,10 +2,11 @@ package apu
 
 import (
 	"github.com/is386/GoBoy/emu/bits"
+	"github.com/is386/GoBoy/emu/state"
 )
 
 var (
-	DIVISORS = map[uint8]int{
+	DIVISORS = [8]int{
 		0x0: 8,
 		0x1: 16,
 		0x2: 32,
@@ -48,11 +49,34 @@ func NewChannel4() *Channel4 {
 	return &Channel4{}
 }
 
-func (c *Channel4) update() {
+func (c *Channel4) serialize(s state.Stream) {
+	s.Int(&c.freqTimer)
+	s.Uint8(&c.envVol)
+	s.Uint8(&c.envPeriod)
+	s.Uint8(&c.envDir)
+	s.Int(&c.envTimer)
+	s.Int(&c.currVol)
+	s.Uint16(&c.lfsr)
+	s.Uint8(&c.shiftAmount)
+	s.Uint8(&c.counterWidth)
+	s.Uint8(&c.divisorCode)
+	s.Uint8(&c.nr43Upper)
+	s.Uint8(&c.triggerBit)
+	s.Uint8(&c.length)
+	s.Int(&c.lengthTimer)
+	s.Uint8(&c.lengthEnabled)
+	s.Uint8(&c.leftOn)
+	s.Uint8(&c.rightOn)
+	s.Int(&c.left)
+	s.Int(&c.right)
+	s.Bool(&c.enabled)
+}
+
+func (c *Channel4) update(cyc int) {
 	var sample int
-	c.freqTimer--
-	if c.freqTimer <= 0 {
-		c.freqTimer = int(DIVISORS[c.divisorCode] << c.shiftAmount)
+	c.freqTimer -= cyc
+	for c.freqTimer <= 0 {
+		c.freqTimer += int(DIVISORS[c.divisorCode] << c.shiftAmount)
 		xorResult := (c.lfsr & 1) ^ ((c.lfsr & 2) >> 1)
 		c.lfsr = (c.lfsr >> 1) | (xorResult << 14)
 
@@ -0,0 +1,108 @@
+package emu
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+)
+
+// BGB link protocol commands.
+var (
+	BGB_VERSION         uint8 = 1
+	BGB_JOYPAD          uint8 = 101
+	BGB_SYNC1           uint8 = 104
+	BGB_SYNC2           uint8 = 105
+	BGB_SYNC3           uint8 = 106
+	BGB_STATUS          uint8 = 108
+	BGB_WANT_DISCONNECT uint8 = 109
+
+	BGB_PACKET_SIZE = 8
+)
+
+// bgbProtocol speaks version 1.4 of the BGB link protocol. Packets are a
+// command, three bytes and a little endian timestamp, in 31 bits of a
+// 2 MiHz clock. A transfer is sync1 from the Game Boy with the clock; the
+// other side answers with sync2, or with sync3 if nothing was shifted.
+// sync3 also carries the clock when there is nothing else to send.
+type bgbProtocol struct {
+	// The other side's clock, counted from its first timestamp.
+	started  bool
+	lastTime uint32
+	time     uint64
+}
+
+func (p *bgbProtocol) start(conn net.Conn) error {
+	if err := p.send(conn, BGB_VERSION, 1, 4, 0, 0); err != nil {
+		return err
+	}
+	packet := make([]uint8, BGB_PACKET_SIZE)
+	if _, err := io.ReadFull(conn, packet); err != nil {
+		return err
+	}
+	if packet[0] != BGB_VERSION || packet[1] != 1 || packet[2] != 4 || packet[3] != 0 {
+		return fmt.Errorf("unsupported BGB link protocol version %d.%d", packet[1], packet[2])
+	}
+	// The Game Boy is running.
+	return p.send(conn, BGB_STATUS, 1, 0, 0, 0)
+}
+
+func (p *bgbProtocol) read(r io.Reader) (linkMessage, error) {
+	packet := make([]uint8, BGB_PACKET_SIZE)
+	for {
+		if _, err := io.ReadFull(r, packet); err != nil {
+			return linkMessage{}, err
+		}
+		cmd, b2, b3 := packet[0], packet[1], packet[2]
+		timestamp := binary.LittleEndian.Uint32(packet[4:])
+
+		switch cmd {
+		case BGB_SYNC1:
+			return linkMessage{kind: LINK_TRANSFER, val: b2, control: b3, time: p.clock(timestamp)}, nil
+		case BGB_SYNC2:
+			return linkMessage{kind: LINK_REPLY, val: b2, time: p.time}, nil
+		case BGB_SYNC3:
+			if b2 == 1 {
+				return linkMessage{kind: LINK_IDLE, val: 0xFF, time: p.time}, nil
+			}
+			return linkMessage{kind: LINK_SYNC, time: p.clock(timestamp)}, nil
+		case BGB_WANT_DISCONNECT:
+			return linkMessage{}, io.EOF
+		}
+		// The joypad and status of the other side, and anything newer,
+		// are ignored.
+	}
+}
+
+// clock turns a timestamp into clocks at 4 MiHz.
+func (p *bgbProtocol) clock(timestamp uint32) uint64 {
+	if !p.started {
+		p.started = true
+		p.lastTime = timestamp
+	}
+	p.time += uint64((timestamp-p.lastTime)&0x7FFFFFFF) * 2
+	p.lastTime = timestamp
+	return p.time
+}
+
+func (p *bgbProtocol) write(w io.Writer, m linkMessage) error {
+	timestamp := uint32(m.time/2) & 0x7FFFFFFF
+	switch m.kind {
+	case LINK_SYNC:
+		return p.send(w, BGB_SYNC3, 0, 0, 0, timestamp)
+	case LINK_TRANSFER:
+		return p.send(w, BGB_SYNC1, m.val, m.control, 0, timestamp)
+	case LINK_REPLY:
+		return p.send(w, BGB_SYNC2, m.val, 0x80, 0, 0)
+	case LINK_IDLE:
+		return p.send(w, BGB_SYNC3, 1, 0, 0, 0)
+	}
+	return nil
+}
+
+func (p *bgbProtocol) send(w io.Writer, cmd, b2, b3, b4 uint8, timestamp uint32) error {
+	packet := []uint8{cmd, b2, b3, b4, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint32(packet[4:], timestamp)
+	_, err := w.Write(packet)
+	return err
+}
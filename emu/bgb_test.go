@@ -0,0 +1,144 @@
+package emu
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestBGBWrite(t *testing.T) {
+	tests := []struct {
+		m    linkMessage
+		want []uint8
+	}{
+		{linkMessage{kind: LINK_SYNC, time: 0x200}, []uint8{BGB_SYNC3, 0, 0, 0, 0x00, 0x01, 0, 0}},
+		{linkMessage{kind: LINK_TRANSFER, val: 0x42, control: 0x81, time: 0x2468},
+			[]uint8{BGB_SYNC1, 0x42, 0x81, 0, 0x34, 0x12, 0, 0}},
+		{linkMessage{kind: LINK_REPLY, val: 0x99, time: 0x2468}, []uint8{BGB_SYNC2, 0x99, 0x80, 0, 0, 0, 0, 0}},
+		{linkMessage{kind: LINK_IDLE, val: 0xFF, time: 0x2468}, []uint8{BGB_SYNC3, 1, 0, 0, 0, 0, 0, 0}},
+		// Timestamps keep 31 bits of the 2 MiHz clock.
+		{linkMessage{kind: LINK_SYNC, time: 0x100000006}, []uint8{BGB_SYNC3, 0, 0, 0, 0x03, 0, 0, 0}},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		p := &bgbProtocol{}
+		if err := p.write(&buf, test.m); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf.Bytes(), test.want) {
+			t.Errorf("write(%+v) is % X, want % X", test.m, buf.Bytes(), test.want)
+		}
+	}
+}
+
+func TestBGBRead(t *testing.T) {
+	packets := [][]uint8{
+		// The first timestamp is the start of the other side's clock.
+		{BGB_SYNC3, 0, 0, 0, 0x00, 0x10, 0, 0},
+		{BGB_SYNC1, 0x42, 0x81, 0, 0x00, 0x11, 0, 0},
+		{BGB_SYNC2, 0x99, 0x80, 0, 0, 0, 0, 0},
+		// Joypad and status packets are skipped.
+		{BGB_JOYPAD, 0x05, 0, 0, 0, 0, 0, 0},
+		{BGB_STATUS, 0x01, 0, 0, 0, 0, 0, 0},
+		{BGB_SYNC3, 1, 0, 0, 0, 0, 0, 0},
+		{BGB_SYNC3, 0, 0, 0, 0x80, 0x11, 0, 0},
+		{BGB_WANT_DISCONNECT, 0, 0, 0, 0, 0, 0, 0},
+	}
+	want := []linkMessage{
+		{kind: LINK_SYNC, time: 0},
+		{kind: LINK_TRANSFER, val: 0x42, control: 0x81, time: 0x200},
+		{kind: LINK_REPLY, val: 0x99, time: 0x200},
+		{kind: LINK_IDLE, val: 0xFF, time: 0x200},
+		{kind: LINK_SYNC, time: 0x300},
+	}
+
+	r := bytes.NewReader(bytes.Join(packets, nil))
+	p := &bgbProtocol{}
+	for i, w := range want {
+		m, err := p.read(r)
+		if err != nil {
+			t.Fatalf("message %d: %v", i, err)
+		}
+		if m != w {
+			t.Errorf("message %d is %+v, want %+v", i, m, w)
+		}
+	}
+	if _, err := p.read(r); err != io.EOF {
+		t.Errorf("read after disconnect returned %v, want EOF", err)
+	}
+}
+
+func TestBGBClock(t *testing.T) {
+	tests := []struct {
+		name       string
+		timestamps []uint32
+		want       uint64
+	}{
+		{"start", []uint32{0x1234}, 0},
+		{"count", []uint32{0x1000, 0x1100, 0x1300}, 0x600},
+		{"wrap", []uint32{0x7FFFFFF0, 0x00000010}, 0x40},
+		{"bit 31", []uint32{0x7FFFFFF0, 0x80000010}, 0x40},
+		{"wrap twice", []uint32{0, 0x40000000, 0x00000000, 0x40000000}, 0x180000000},
+	}
+	for _, test := range tests {
+		p := &bgbProtocol{}
+		var got uint64
+		for _, ts := range test.timestamps {
+			got = p.clock(ts)
+		}
+		if got != test.want {
+			t.Errorf("%s: clock is %X, want %X", test.name, got, test.want)
+		}
+	}
+}
+
+func TestBGBHandshake(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply []uint8
+		ok    bool
+	}{
+		{"1.4", []uint8{BGB_VERSION, 1, 4, 0, 0, 0, 0, 0}, true},
+		{"1.3", []uint8{BGB_VERSION, 1, 3, 0, 0, 0, 0, 0}, false},
+		{"not a version", []uint8{BGB_SYNC3, 1, 4, 0, 0, 0, 0, 0}, false},
+		{"truncated", []uint8{BGB_VERSION, 1}, false},
+	}
+	for _, test := range tests {
+		conn, peer := net.Pipe()
+		got := make(chan []uint8)
+		go func() {
+			var packets []uint8
+			packet := make([]uint8, BGB_PACKET_SIZE)
+			if _, err := io.ReadFull(peer, packet); err == nil {
+				packets = append(packets, packet...)
+				peer.Write(test.reply)
+				if len(test.reply) < BGB_PACKET_SIZE {
+					peer.Close()
+				}
+				if _, err := io.ReadFull(peer, packet); err == nil {
+					packets = append(packets, packet...)
+				}
+			}
+			got <- packets
+		}()
+
+		p := &bgbProtocol{}
+		err := p.start(conn)
+		conn.Close()
+		packets := <-got
+		peer.Close()
+
+		if (err == nil) != test.ok {
+			t.Errorf("%s: start returned %v", test.name, err)
+		}
+		want := []uint8{BGB_VERSION, 1, 4, 0, 0, 0, 0, 0}
+		if test.ok {
+			// The Game Boy is running.
+			want = append(want, BGB_STATUS, 1, 0, 0, 0, 0, 0, 0)
+		}
+		if !bytes.Equal(packets, want) {
+			t.Errorf("%s: sent % X, want % X", test.name, packets, want)
+		}
+	}
+}
@@ -1,8 +1,6 @@
 package emu
 
-import (
-	"github.com/veandco/go-sdl2/sdl"
-)
+import "github.com/is386/GoBoy/emu/state"
 
 type Buttons struct {
 	gb     *GameBoy
@@ -17,20 +15,9 @@ func NewButtons(gb *GameBoy) *Buttons {
 	return b
 }
 
-func (b *Buttons) update() {
-	for event := sdl.PollEvent(); event != nil; event = sdl.PollEvent() {
-		switch e := event.(type) {
-		case *sdl.QuitEvent:
-			b.gb.close()
-		case *sdl.KeyboardEvent:
-			switch e.Type {
-			case sdl.KEYDOWN:
-				b.keyDown(e.Keysym.Sym)
-			case sdl.KEYUP:
-				b.keyUp(e.Keysym.Sym)
-			}
-		}
-	}
+func (b *Buttons) serialize(s state.Stream) {
+	s.Bytes(b.rows[:])
+	s.Uint8(&b.column)
 }
 
 func (b *Buttons) readByte(addr uint16) uint8 {
@@ -52,63 +39,33 @@ func (b *Buttons) writeByte(addr uint16, val uint8) {
 	}
 }
 
-func (b *Buttons) keyDown(key sdl.Keycode) {
-	bHit := false
-	dHit := false
-
-	switch key {
-	case sdl.K_RETURN: // Start
-		b.rows[0] &= 0x7
-		bHit = true
-	case sdl.K_RSHIFT: // Select
-		b.rows[0] &= 0xB
-		bHit = true
-	case sdl.K_w: // Up
-		b.rows[1] &= 0xB
-		dHit = true
-	case sdl.K_s: // Down
-		b.rows[1] &= 0x7
-		dHit = true
-	case sdl.K_a: // Left
-		b.rows[1] &= 0xD
-		dHit = true
-	case sdl.K_d: // Right
-		b.rows[1] &= 0xE
-		dHit = true
-	case sdl.K_j: // A
-		b.rows[0] &= 0xE
-		bHit = true
-	case sdl.K_k: // B
-		b.rows[0] &= 0xD
-		bHit = true
-	case sdl.K_ESCAPE:
-		b.gb.close()
+// isLineLow reports whether a button held down pulls one of the P10-P13
+// lines low, in the rows that P14 and P15 select.
+func (b *Buttons) isLineLow() bool {
+	lines := uint8(0x0F)
+	if b.column&0x20 == 0 {
+		lines &= b.rows[0]
 	}
-
-	if (dHit && b.column == 0x10) || (bHit && b.column == 0x20) {
-		b.gb.mmu.writeInterrupt(INT_JOYPAD)
+	if b.column&0x10 == 0 {
+		lines &= b.rows[1]
 	}
+	return lines != 0x0F
 }
 
-func (b *Buttons) keyUp(key sdl.Keycode) {
-	switch key {
-	case sdl.K_RETURN: // Start
-		b.rows[0] |= 0x8
-	case sdl.K_RSHIFT: // Select
-		b.rows[0] |= 0x4
-	case sdl.K_w: // Up
-		b.rows[1] |= 0x4
-	case sdl.K_s: // Down
-		b.rows[1] |= 0x8
-	case sdl.K_a: // Left
-		b.rows[1] |= 0x2
-	case sdl.K_d: // Right
-		b.rows[1] |= 0x1
-	case sdl.K_j: // A
-		b.rows[0] |= 0x1
-	case sdl.K_k: // B
-		b.rows[0] |= 0x2
-	case sdl.K_ESCAPE:
-		b.gb.close()
+// state returns the BUTTON_* mask of the buttons held down.
+func (b *Buttons) state() uint8 {
+	return (^b.rows[0] & 0x0F) | ((^b.rows[1] & 0x0F) << 4)
+}
+
+func (b *Buttons) setState(pressed uint8) {
+	// A row bit is 0 while its button is held down
+	rows := [2]uint8{^pressed & 0x0F, (^pressed >> 4) & 0x0F}
+
+	bHit := (b.rows[0] &^ rows[0]) != 0
+	dHit := (b.rows[1] &^ rows[1]) != 0
+	b.rows = rows
+
+	if (dHit && b.column == 0x10) || (bHit && b.column == 0x20) {
+		b.gb.mmu.writeInterrupt(INT_JOYPAD)
 	}
 }
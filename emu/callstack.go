@@ -0,0 +1,181 @@
+package emu
+
+import "fmt"
+
+var (
+	FRAME_CALL      = 0
+	FRAME_RST       = 1
+	FRAME_INTERRUPT = 2
+
+	IMBALANCE_NO_RETURN    = 0
+	IMBALANCE_NO_CALL      = 1
+	IMBALANCE_WRONG_RETURN = 2
+
+	INT_NAMES = map[int]string{
+		INT_VBLANK: "vblank",
+		INT_LCD:    "lcd",
+		INT_TIMER:  "timer",
+		INT_SERIAL: "serial",
+		INT_JOYPAD: "joypad",
+	}
+)
+
+// CallFrame is a CALL, RST or interrupt whose return address is on the
+// stack. CallSite is the address of the CALL or RST instruction, or the
+// PC that the interrupt stopped. SP is where the return address was pushed.
+type CallFrame struct {
+	Kind      int
+	Interrupt int
+	Bank      int
+	Target    uint16
+	CallBank  int
+	CallSite  uint16
+	Return    uint16
+	SP        uint16
+}
+
+// Imbalance is a return that does not match the call stack. It is only
+// formatted when the debugger reports it, since some games manipulate the
+// stack all the time.
+type Imbalance struct {
+	Kind  int
+	Frame CallFrame
+	Bank  int
+	Addr  uint16
+}
+
+// CallStack shadows the return addresses that the CPU pushes and pops, so
+// that the call chain can be shown without unwinding the stack by hand. It
+// is only attached while the debugger, GDB or the profiler needs it.
+type CallStack struct {
+	gb     *GameBoy
+	frames []CallFrame
+
+	// gen changes whenever frames does.
+	gen uint64
+
+	// Events of the last instruction, for the debugger to pick up.
+	returned  *CallFrame
+	imbalance *Imbalance
+}
+
+func NewCallStack(gb *GameBoy) *CallStack {
+	return &CallStack{gb: gb}
+}
+
+// attachCallStack starts shadowing the call stack, if it is not already.
+func (gb *GameBoy) attachCallStack() {
+	if gb.calls == nil {
+		gb.calls = NewCallStack(gb)
+		gb.cpu.calls = gb.calls
+	}
+}
+
+// Frames returns the call chain, outermost first.
+func (cs *CallStack) Frames() []CallFrame {
+	return cs.frames
+}
+
+func (cs *CallStack) reset() {
+	cs.frames = cs.frames[:0]
+	cs.gen++
+}
+
+// interrupts returns the interrupt handlers that are running, outermost
+// first.
+func (cs *CallStack) interrupts() []string {
+	var names []string
+	for _, f := range cs.frames {
+		if f.Kind == FRAME_INTERRUPT {
+			names = append(names, INT_NAMES[f.Interrupt])
+		}
+	}
+	return names
+}
+
+// call is run after the CPU pushed ret and jumped to target.
+func (cs *CallStack) call(kind int, target, ret uint16) {
+	site := ret
+	switch kind {
+	case FRAME_CALL:
+		site -= 3
+	case FRAME_RST:
+		site--
+	}
+	f := CallFrame{Kind: kind, Bank: cs.gb.bankOf(target), Target: target,
+		CallBank: cs.gb.bankOf(site), CallSite: site, Return: ret, SP: cs.gb.cpu.sp}
+
+	// Frames at or below the new return address were left without a
+	// return, e.g. by popping the return address or resetting SP.
+	for len(cs.frames) > 0 && cs.top().SP <= f.SP {
+		cs.drop()
+	}
+	cs.frames = append(cs.frames, f)
+	cs.gen++
+}
+
+// interrupt is run after the CPU dispatched interrupt i.
+func (cs *CallStack) interrupt(i int, ret uint16) {
+	cs.call(FRAME_INTERRUPT, INT_ADDR[i], ret)
+	cs.top().Interrupt = i
+}
+
+// ret is run after the CPU popped addr from sp and jumped to it.
+func (cs *CallStack) ret(sp, addr uint16) {
+	for len(cs.frames) > 0 && cs.top().SP < sp {
+		cs.drop()
+	}
+	if len(cs.frames) == 0 || cs.top().SP != sp {
+		cs.imbalance = &Imbalance{Kind: IMBALANCE_NO_CALL, Bank: cs.gb.bankOf(addr), Addr: addr}
+		return
+	}
+
+	f := *cs.top()
+	cs.frames = cs.frames[:len(cs.frames)-1]
+	cs.gen++
+	cs.returned = &f
+	if addr != f.Return {
+		cs.imbalance = &Imbalance{Kind: IMBALANCE_WRONG_RETURN, Frame: f, Bank: cs.gb.bankOf(addr), Addr: addr}
+	}
+}
+
+func (cs *CallStack) top() *CallFrame {
+	return &cs.frames[len(cs.frames)-1]
+}
+
+// drop removes the top frame, which was left without a return.
+func (cs *CallStack) drop() {
+	cs.imbalance = &Imbalance{Kind: IMBALANCE_NO_RETURN, Frame: *cs.top()}
+	cs.frames = cs.frames[:len(cs.frames)-1]
+	cs.gen++
+}
+
+func (cs *CallStack) describeImbalance(im *Imbalance) string {
+	switch im.Kind {
+	case IMBALANCE_NO_CALL:
+		return fmt.Sprintf("return to %s does not match a call", cs.location(im.Bank, im.Addr))
+	case IMBALANCE_WRONG_RETURN:
+		return fmt.Sprintf("return to %s instead of %s from %s",
+			cs.location(im.Bank, im.Addr), cs.location(im.Frame.CallBank, im.Frame.Return), cs.describe(im.Frame))
+	}
+	return "no return from " + cs.describe(im.Frame)
+}
+
+func (cs *CallStack) location(bank int, addr uint16) string {
+	s := fmt.Sprintf("%02X:%04X", bank, addr)
+	if label := cs.gb.symbols.Describe(bank, addr); label != "" {
+		s += " " + label
+	}
+	return s
+}
+
+// describe says how a frame was entered, e.g. "call 00:0200 Work".
+func (cs *CallStack) describe(f CallFrame) string {
+	switch f.Kind {
+	case FRAME_RST:
+		return "rst " + cs.location(f.Bank, f.Target)
+	case FRAME_INTERRUPT:
+		return "interrupt " + INT_NAMES[f.Interrupt]
+	}
+	return "call " + cs.location(f.Bank, f.Target)
+}
@@ -0,0 +1,155 @@
+package emu
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// CALLS calls 0170, which runs RST $38, then halts until a VBlank interrupt
+// runs the handler at 0040.
+var CALLS = append([]uint8{
+	0x31, 0x00, 0xD0, // LD SP,$D000
+	0xCD, 0x70, 0x01, // CALL $0170
+	0x3E, 0x01, 0xE0, 0xFF, // LD A,$01; LDH ($FF),A
+	0xFB,       // EI
+	0x76,       // HALT
+	0x18, 0xFE, // JR -2
+}, append(make([]uint8, 0x12),
+	0xFF, // RST $38
+	0xC9, // RET
+)...)
+
+func newCallsGameBoy(t *testing.T) *GameBoy {
+	path := testROM(t, CALLS, false)
+	rom, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rom[0x38] = 0xC9 // RET
+	if err := ioutil.WriteFile(path, rom, 0644); err != nil {
+		t.Fatal(err)
+	}
+	gb := NewGameBoy(path, "", false, NullVideo{}, NullAudio{}, NullInput{})
+	gb.attachCallStack()
+	return gb
+}
+
+// stepTo steps gb until PC is at addr.
+func stepTo(t *testing.T, gb *GameBoy, addr uint16) {
+	for i := 0; gb.cpu.pc != addr; i++ {
+		if i == 100000 {
+			t.Fatalf("PC did not reach %04X", addr)
+		}
+		gb.step()
+	}
+}
+
+func TestCallStackFrames(t *testing.T) {
+	if gb := newTestGameBoy(t, CALLS, false); gb.calls != nil || gb.cpu.calls != nil {
+		t.Error("the call stack is attached without the debugger, GDB or the profiler")
+	}
+	gb := newCallsGameBoy(t)
+	tests := []struct {
+		pc       uint16
+		frames   []CallFrame
+		returned int
+	}{
+		{0x0170, []CallFrame{
+			{Kind: FRAME_CALL, Target: 0x0170, CallSite: 0x0153, Return: 0x0156, SP: 0xCFFE},
+		}, -1},
+		{0x0038, []CallFrame{
+			{Kind: FRAME_CALL, Target: 0x0170, CallSite: 0x0153, Return: 0x0156, SP: 0xCFFE},
+			{Kind: FRAME_RST, Target: 0x0038, CallSite: 0x0170, Return: 0x0171, SP: 0xCFFC},
+		}, -1},
+		{0x0171, []CallFrame{
+			{Kind: FRAME_CALL, Target: 0x0170, CallSite: 0x0153, Return: 0x0156, SP: 0xCFFE},
+		}, FRAME_RST},
+		{0x0156, nil, FRAME_CALL},
+		// VBlank is already requested, so it is taken before HALT runs.
+		{0x0040, []CallFrame{
+			{Kind: FRAME_INTERRUPT, Interrupt: INT_VBLANK, Target: 0x0040, CallSite: 0x015B, Return: 0x015B, SP: 0xCFFE},
+		}, -1},
+		// RETI pops the interrupt frame.
+		{0x015B, nil, FRAME_INTERRUPT},
+	}
+	for _, test := range tests {
+		gb.calls.returned = nil
+		stepTo(t, gb, test.pc)
+		frames := gb.calls.Frames()
+		if len(frames) != len(test.frames) {
+			t.Fatalf("at %04X: %d frames, want %d", test.pc, len(frames), len(test.frames))
+		}
+		for i := range frames {
+			if frames[i] != test.frames[i] {
+				t.Errorf("at %04X: frame %d is %+v, want %+v", test.pc, i, frames[i], test.frames[i])
+			}
+		}
+		returned := -1
+		if gb.calls.returned != nil {
+			returned = gb.calls.returned.Kind
+		}
+		if returned != test.returned {
+			t.Errorf("at %04X: returned from kind %d, want %d", test.pc, returned, test.returned)
+		}
+		if gb.calls.imbalance != nil {
+			t.Errorf("at %04X: %s", test.pc, gb.calls.describeImbalance(gb.calls.imbalance))
+		}
+	}
+}
+
+func TestCallStackImbalance(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(cs *CallStack, c *CPU)
+		want string
+	}{
+		{"return without a call", func(cs *CallStack, c *CPU) {
+			cs.ret(0xD000, 0x1234)
+		}, "return to 00:1234 does not match a call"},
+		{"wrong return address", func(cs *CallStack, c *CPU) {
+			c.sp = 0xCFFE
+			cs.call(FRAME_CALL, 0x0200, 0x0156)
+			cs.ret(0xCFFE, 0x0300)
+		}, "return to 00:0300 instead of 00:0156 from call 00:0200"},
+		{"return address popped", func(cs *CallStack, c *CPU) {
+			c.sp = 0xCFFE
+			cs.call(FRAME_RST, 0x0038, 0x0156)
+			cs.call(FRAME_CALL, 0x0200, 0x0160)
+		}, "no return from rst 00:0038"},
+		{"frame skipped by a return", func(cs *CallStack, c *CPU) {
+			c.sp = 0xCFFE
+			cs.call(FRAME_CALL, 0x0200, 0x0156)
+			c.sp = 0xCFFC
+			cs.interrupt(INT_TIMER, 0x0210)
+			cs.ret(0xCFFE, 0x0156)
+		}, "no return from interrupt timer"},
+	}
+	for _, test := range tests {
+		gb := newCallsGameBoy(t)
+		test.run(gb.calls, gb.cpu)
+		if gb.calls.imbalance == nil {
+			t.Errorf("%s: no imbalance", test.name)
+			continue
+		}
+		if got := gb.calls.describeImbalance(gb.calls.imbalance); got != test.want {
+			t.Errorf("%s: %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestStepOut(t *testing.T) {
+	gb := newCallsGameBoy(t)
+	var out bytes.Buffer
+	gb.debugger = NewDebugger(gb, strings.NewReader("b 0170\nc\nfinish\nc\n"), &out)
+	for i := 0; i < 1000; i++ {
+		gb.step()
+	}
+
+	// The RST and its RET inside 0170 do not stop it.
+	stops := strings.Count(out.String(), "Stopped:")
+	if !strings.Contains(out.String(), "Stopped: step out\nAF: 0180 BC: 0013 DE: 00D8 HL: 014D SP: D000 PC: 00:0156") || stops != 3 {
+		t.Errorf("did not step out to 0156 once:\n%s", out.String())
+	}
+}
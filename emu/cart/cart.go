@@ -6,6 +6,8 @@ import (
 	"math"
 	"os"
 	"strings"
+
+	"github.com/is386/GoBoy/emu/state"
 )
 
 var (
@@ -23,13 +25,16 @@ type Cartridge struct {
 	mbc         MBC
 	name        string
 	romFileName string
+	checksum    uint16
+	romSize     int
+	ramSize     int
 	canSave     bool
 	isDMGCart   bool
 }
 
 func NewCartridge(filename string, rom []uint8) *Cartridge {
 	i := strings.LastIndex(filename, ".")
-	cart := &Cartridge{romFileName: filename[:i]}
+	cart := &Cartridge{romFileName: filename[:i], romSize: len(rom)}
 
 	if rom[0x143] != 0xC0 && rom[0x143] != 0x80 {
 		cart.isDMGCart = true
@@ -42,10 +47,12 @@ func NewCartridge(filename string, rom []uint8) *Cartridge {
 		cart.name += fmt.Sprintf("%c", rom[i])
 	}
 	cart.name = strings.TrimSpace(cart.name)
+	cart.checksum = (uint16(rom[0x14E]) << 8) | uint16(rom[0x14F])
 
 	mbcType := rom[0x147]
 	romBanks := int(math.Pow(2, float64(rom[0x148])+1))
 	ramBanks := RAM_BANKS[rom[0x149]]
+	cart.ramSize = ramBanks * 0x2000
 
 	switch mbcType {
 
@@ -97,10 +104,43 @@ func (c *Cartridge) GetName() string {
 	return c.name
 }
 
+func (c *Cartridge) GetFileName() string {
+	return c.romFileName
+}
+
+func (c *Cartridge) GetChecksum() uint16 {
+	return c.checksum
+}
+
+func (c *Cartridge) GetRomSize() int {
+	return c.romSize
+}
+
+func (c *Cartridge) GetRamSize() int {
+	return c.ramSize
+}
+
+// RomOffset returns the offset in the ROM file of the byte mapped at addr,
+// which must be below 0x8000.
+func (c *Cartridge) RomOffset(addr uint16) int {
+	if addr < 0x4000 {
+		return int(c.mbc.getRomBank0())*0x4000 + int(addr)
+	}
+	return int(c.mbc.getRomBank())*0x4000 + int(addr-0x4000)
+}
+
+func (c *Cartridge) Serialize(s state.Stream) {
+	c.mbc.serialize(s)
+}
+
 func (c *Cartridge) GetRomBank() uint32 {
 	return c.mbc.getRomBank()
 }
 
+func (c *Cartridge) GetRamBank() uint32 {
+	return c.mbc.getRamBank()
+}
+
 func (c *Cartridge) Save() {
 	if !c.canSave {
 		return
@@ -126,6 +166,20 @@ func (c *Cartridge) Load() {
 	}
 }
 
+// ClearRAM wipes the cartridge RAM, e.g. so that a movie starts from the
+// same state no matter which battery save was loaded.
+func (c *Cartridge) ClearRAM() {
+	c.mbc.loadData(make([]uint8, len(c.mbc.saveData())))
+}
+
+// SetClock replaces the wall clock used by the RTC, if the cartridge has
+// one, with a function returning Unix seconds.
+func (c *Cartridge) SetClock(now func() int64) {
+	if m, ok := c.mbc.(*MBC3); ok {
+		m.rtc.setClock(now)
+	}
+}
+
 func (c *Cartridge) IsDMGCart() bool {
 	return c.isDMGCart
 }
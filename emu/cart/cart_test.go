@@ -0,0 +1,64 @@
+package cart
+
+import "testing"
+
+// mbc1ROM returns a 1 MiB MBC1 ROM with the number of each bank at 0x1000
+// in the bank.
+func mbc1ROM() []uint8 {
+	rom := make([]uint8, 64*0x4000)
+	rom[0x147] = 0x01
+	rom[0x148] = 0x05
+	for bank := 0; bank < 64; bank++ {
+		rom[bank*0x4000+0x1000] = uint8(bank)
+	}
+	return rom
+}
+
+func TestMBC1Banks(t *testing.T) {
+	tests := []struct {
+		name         string
+		writes       [][2]uint16
+		bank0, bankN int
+	}{
+		{"power on", nil, 0x00, 0x01},
+		{"bank 0 selects bank 1", [][2]uint16{{0x2000, 0x00}}, 0x00, 0x01},
+		{"lower bits", [][2]uint16{{0x2000, 0x05}}, 0x00, 0x05},
+		{"upper bits in mode 0", [][2]uint16{{0x2000, 0x05}, {0x4000, 0x01}}, 0x00, 0x25},
+		{"upper bits in mode 1", [][2]uint16{{0x2000, 0x05}, {0x4000, 0x01}, {0x6000, 0x01}}, 0x20, 0x25},
+		{"upper bits past the end", [][2]uint16{{0x6000, 0x01}, {0x4000, 0x02}}, 0x00, 0x01},
+		{"back to mode 0", [][2]uint16{{0x4000, 0x01}, {0x6000, 0x01}, {0x6000, 0x00}}, 0x00, 0x21},
+	}
+	for _, test := range tests {
+		c := NewCartridge("test.gb", mbc1ROM())
+		for _, w := range test.writes {
+			c.WriteROM(w[0], uint8(w[1]))
+		}
+		if got := c.ReadByte(0x1000); int(got) != test.bank0 {
+			t.Errorf("%s: bank %02X is mapped at 0000, want %02X", test.name, got, test.bank0)
+		}
+		if got := c.ReadByte(0x5000); int(got) != test.bankN {
+			t.Errorf("%s: bank %02X is mapped at 4000, want %02X", test.name, got, test.bankN)
+		}
+		if got, want := c.RomOffset(0x1000), test.bank0*0x4000+0x1000; got != want {
+			t.Errorf("%s: RomOffset(1000) is %X, want %X", test.name, got, want)
+		}
+		if got, want := c.RomOffset(0x5000), test.bankN*0x4000+0x1000; got != want {
+			t.Errorf("%s: RomOffset(5000) is %X, want %X", test.name, got, want)
+		}
+	}
+}
+
+func TestMBC0Banks(t *testing.T) {
+	rom := make([]uint8, 2*0x4000)
+	rom[0x5000] = 0x42
+	c := NewCartridge("test.gb", rom)
+	if bank := c.GetRomBank(); bank != 1 {
+		t.Errorf("bank %d is mapped at 4000, want 1", bank)
+	}
+	if got := c.RomOffset(0x5000); got != 0x5000 {
+		t.Errorf("RomOffset(5000) is %X, want 5000", got)
+	}
+	if got := c.ReadByte(0x5000); got != 0x42 {
+		t.Errorf("read %02X from 5000, want 42", got)
+	}
+}
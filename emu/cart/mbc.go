@@ -1,10 +1,16 @@
 package cart
 
+import "github.com/is386/GoBoy/emu/state"
+
 type MBC interface {
 	readByte(addr uint16) uint8
 	writeROM(addr uint16, val uint8)
 	writeRAM(addr uint16, val uint8)
 	getRomBank() uint32
+	// getRomBank0 returns the ROM bank mapped at 0x0000-0x3FFF.
+	getRomBank0() uint32
+	getRamBank() uint32
 	saveData() []uint8
 	loadData(data []uint8)
+	serialize(s state.Stream)
 }
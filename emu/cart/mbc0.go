@@ -1,5 +1,7 @@
 package cart
 
+import "github.com/is386/GoBoy/emu/state"
+
 type MBC0 struct {
 	ROM []uint8
 	RAM [0x2000]uint8
@@ -25,7 +27,16 @@ func (m *MBC0) writeRAM(addr uint16, val uint8) {
 	m.RAM[addr-0xA000] = val
 }
 
+// Without an MBC, 4000-7FFF always maps the second bank.
 func (m *MBC0) getRomBank() uint32 {
+	return 1
+}
+
+func (m *MBC0) getRomBank0() uint32 {
+	return 0
+}
+
+func (m *MBC0) getRamBank() uint32 {
 	return 0
 }
 
@@ -35,3 +46,7 @@ func (m *MBC0) loadData(data []uint8) {
 func (m *MBC0) saveData() []uint8 {
 	return nil
 }
+
+func (m *MBC0) serialize(s state.Stream) {
+	s.Bytes(m.RAM[:])
+}
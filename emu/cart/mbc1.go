@@ -1,5 +1,9 @@
 package cart
 
+import "github.com/is386/GoBoy/emu/state"
+
+// MBC1 is in mode 0 while advBankingEnabled is set. The upper bits then only
+// select the ROM bank at 0x4000-0x7FFF.
 type MBC1 struct {
 	ROM               []uint8
 	RAM               []uint8
@@ -14,11 +18,12 @@ type MBC1 struct {
 
 func NewMBC1(rom []uint8, romBanks uint32, ramBanks uint32) MBC {
 	mbc := &MBC1{
-		ROM:           rom,
-		RAM:           make([]uint8, (ramBanks+1)*0x2000),
-		romBank:       1,
-		totalRomBanks: romBanks,
-		totalRamBanks: ramBanks}
+		ROM:               rom,
+		RAM:               make([]uint8, (ramBanks+1)*0x2000),
+		romBank:           1,
+		totalRomBanks:     romBanks,
+		totalRamBanks:     ramBanks,
+		advBankingEnabled: true}
 	return mbc
 }
 
@@ -26,7 +31,7 @@ func (m *MBC1) readByte(addr uint16) uint8 {
 	switch addr & 0xF000 {
 
 	case 0x0000, 0x1000, 0x2000, 0x3000:
-		return m.ROM[addr]
+		return m.ROM[(uint32(m.getRomBank0()*0x4000) + uint32(addr))]
 
 	case 0x4000, 0x5000, 0x6000, 0x7000:
 		return m.ROM[(uint32(m.romBank*0x4000) + uint32(addr-0x4000))]
@@ -56,21 +61,23 @@ func (m *MBC1) writeROM(addr uint16, val uint8) {
 		}
 		m.romBank = ((m.romBankUpperBits << 5) | uint32(val&0x1F)) % m.totalRomBanks
 
+	// The upper bits always select the ROM bank at 0x4000-0x7FFF. In mode 1
+	// they also select the ROM bank at 0x0000-0x3FFF and the RAM bank.
 	case 0x4000, 0x5000:
-		if m.advBankingEnabled {
-			m.romBankUpperBits = uint32(val & 0x3)
-		} else if m.totalRamBanks > 0 {
-			m.ramBank = (uint32(val & 0x3)) % m.totalRamBanks
-		}
+		m.romBankUpperBits = uint32(val & 0x3)
+		m.romBank = ((m.romBankUpperBits << 5) | (m.romBank & 0x1F)) % m.totalRomBanks
+		m.updateRamBank()
 
 	case 0x6000, 0x7000:
 		m.advBankingEnabled = (val & 1) == 0
-		if m.advBankingEnabled {
-			m.ramBank = 0
-		} else {
-			m.romBank &= 0x1F
-			m.romBankUpperBits = 0
-		}
+		m.updateRamBank()
+	}
+}
+
+func (m *MBC1) updateRamBank() {
+	m.ramBank = 0
+	if !m.advBankingEnabled && m.totalRamBanks > 0 {
+		m.ramBank = m.romBankUpperBits % m.totalRamBanks
 	}
 }
 
@@ -84,6 +91,17 @@ func (m *MBC1) getRomBank() uint32 {
 	return m.romBank
 }
 
+func (m *MBC1) getRomBank0() uint32 {
+	if m.advBankingEnabled {
+		return 0
+	}
+	return (m.romBankUpperBits << 5) % m.totalRomBanks
+}
+
+func (m *MBC1) getRamBank() uint32 {
+	return m.ramBank
+}
+
 func (m *MBC1) loadData(data []uint8) {
 	m.RAM = data
 }
@@ -91,3 +109,12 @@ func (m *MBC1) loadData(data []uint8) {
 func (m *MBC1) saveData() []uint8 {
 	return m.RAM
 }
+
+func (m *MBC1) serialize(s state.Stream) {
+	s.Slice(&m.RAM)
+	s.Uint32(&m.romBank)
+	s.Uint32(&m.romBankUpperBits)
+	s.Uint32(&m.ramBank)
+	s.Bool(&m.ramEnabled)
+	s.Bool(&m.advBankingEnabled)
+}
@@ -1,5 +1,7 @@
 package cart
 
+import "github.com/is386/GoBoy/emu/state"
+
 type MBC3 struct {
 	ROM           []uint8
 	RAM           []uint8
@@ -77,6 +79,14 @@ func (m *MBC3) getRomBank() uint32 {
 	return m.romBank
 }
 
+func (m *MBC3) getRomBank0() uint32 {
+	return 0
+}
+
+func (m *MBC3) getRamBank() uint32 {
+	return m.ramBank
+}
+
 func (m *MBC3) loadData(data []uint8) {
 	m.RAM = data
 }
@@ -84,3 +94,11 @@ func (m *MBC3) loadData(data []uint8) {
 func (m *MBC3) saveData() []uint8 {
 	return m.RAM
 }
+
+func (m *MBC3) serialize(s state.Stream) {
+	s.Slice(&m.RAM)
+	s.Uint32(&m.romBank)
+	s.Uint32(&m.ramBank)
+	s.Bool(&m.ramEnabled)
+	m.rtc.serialize(s)
+}
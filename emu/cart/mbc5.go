@@ -1,5 +1,7 @@
 package cart
 
+import "github.com/is386/GoBoy/emu/state"
+
 type MBC5 struct {
 	ROM           []uint8
 	RAM           []uint8
@@ -69,6 +71,14 @@ func (m *MBC5) getRomBank() uint32 {
 	return m.romBank
 }
 
+func (m *MBC5) getRomBank0() uint32 {
+	return 0
+}
+
+func (m *MBC5) getRamBank() uint32 {
+	return m.ramBank
+}
+
 func (m *MBC5) loadData(data []uint8) {
 	m.RAM = data
 }
@@ -76,3 +86,10 @@ func (m *MBC5) loadData(data []uint8) {
 func (m *MBC5) saveData() []uint8 {
 	return m.RAM
 }
+
+func (m *MBC5) serialize(s state.Stream) {
+	s.Slice(&m.RAM)
+	s.Uint32(&m.romBank)
+	s.Uint32(&m.ramBank)
+	s.Bool(&m.ramEnabled)
+}
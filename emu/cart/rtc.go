@@ -1,6 +1,10 @@
 package cart
 
-import "time"
+import (
+	"time"
+
+	"github.com/is386/GoBoy/emu/state"
+)
 
 type RTC struct {
 	time     int64
@@ -10,10 +14,32 @@ type RTC struct {
 	hr       uint8
 	day      uint8
 	latchVal uint8
+	now      func() int64
 }
 
 func NewRTC() *RTC {
-	return &RTC{lastTime: time.Now().Unix()}
+	r := &RTC{now: wallClock}
+	r.lastTime = r.now()
+	return r
+}
+
+func wallClock() int64 {
+	return time.Now().Unix()
+}
+
+func (r *RTC) setClock(now func() int64) {
+	r.now = now
+	r.lastTime = r.now()
+}
+
+func (r *RTC) serialize(s state.Stream) {
+	s.Int64(&r.time)
+	s.Int64(&r.lastTime)
+	s.Uint8(&r.sec)
+	s.Uint8(&r.min)
+	s.Uint8(&r.hr)
+	s.Uint8(&r.day)
+	s.Uint8(&r.latchVal)
 }
 
 func (r *RTC) latch(val uint8) {
@@ -65,7 +91,7 @@ func (r *RTC) write(reg int8, val uint8) {
 }
 
 func (r *RTC) update() {
-	t := time.Now().Unix()
+	t := r.now()
 	r.time += t - r.lastTime
 	r.lastTime = t
 }
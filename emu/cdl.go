@@ -0,0 +1,91 @@
+package emu
+
+import (
+	"os"
+
+	"github.com/is386/GoBoy/emu/cdl"
+)
+
+// CodeDataLogger marks the bytes of ROM, WRAM, HRAM and cartridge RAM that
+// the CPU executes or reads, and the ROM bytes that DMA copies. opcode is set
+// before each instruction, so that its first fetch is marked as ExecFirst.
+type CodeDataLogger struct {
+	gb       *GameBoy
+	log      *cdl.Log
+	filename string
+	opcode   bool
+}
+
+// StartCDL starts logging to filename. A log that is already in the file
+// is merged in, so coverage adds up across sessions.
+func (gb *GameBoy) StartCDL(filename string) error {
+	wram := 0x2000
+	if gb.isCGB {
+		wram = 0x8000
+	}
+	log := cdl.New(gb.cart.GetRomSize(), wram, gb.cart.GetRamSize())
+	if old, err := cdl.Load(filename); err == nil {
+		if err := log.Merge(old); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	gb.cdl = &CodeDataLogger{gb: gb, log: log, filename: filename}
+	return nil
+}
+
+// CDL returns the running log, or nil.
+func (gb *GameBoy) CDL() *cdl.Log {
+	if gb.cdl == nil {
+		return nil
+	}
+	return gb.cdl.log
+}
+
+// SaveCDL writes the log to the file given to StartCDL.
+func (gb *GameBoy) SaveCDL() error {
+	if gb.cdl == nil {
+		return nil
+	}
+	return gb.cdl.log.Save(gb.cdl.filename)
+}
+
+// fetch marks an instruction byte fetched from addr.
+func (l *CodeDataLogger) fetch(addr uint16) {
+	if l.opcode {
+		l.opcode = false
+		l.mark(addr, cdl.EXEC_FIRST)
+		return
+	}
+	l.mark(addr, cdl.EXEC_OPERAND)
+}
+
+// mark flags the byte mapped at addr. The boot ROM, VRAM, OAM and the I/O
+// registers are not logged.
+func (l *CodeDataLogger) mark(addr uint16, flags uint8) {
+	switch {
+	case addr < 0x8000:
+		if !l.gb.mmu.isBootROM(addr) {
+			l.log.Mark(cdl.ROM, l.gb.cart.RomOffset(addr), flags)
+		}
+
+	case addr >= 0xA000 && addr < 0xC000:
+		// The clock registers of the MBC3 are mapped as banks past the RAM.
+		offset := int(l.gb.cart.GetRamBank())*0x2000 + int(addr-0xA000)
+		if offset < len(l.log.Blocks[cdl.CART_RAM]) {
+			l.log.Mark(cdl.CART_RAM, offset, flags)
+		}
+
+	case addr >= 0xC000 && addr < 0xFE00:
+		// E000-FDFF echoes C000-DDFF.
+		offset := int(addr-0xC000) & 0x1FFF
+		if offset >= 0x1000 {
+			offset += int(l.gb.mmu.wramBank-1) * 0x1000
+		}
+		l.log.Mark(cdl.WRAM, offset, flags)
+
+	case addr >= 0xFF80:
+		l.log.Mark(cdl.HRAM, int(addr-0xFF80), flags)
+	}
+}
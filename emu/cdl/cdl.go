@@ -0,0 +1,259 @@
+// Package cdl reads and writes code/data logs in the layout of BizHawk's GB
+// core: a "BIZHAWK-CDL-2" header followed by named blocks for the ROM, WRAM,
+// HRAM and cartridge RAM, with one flag byte for every byte of each. The
+// offset of a flag in its block is the offset of its byte in that memory,
+// e.g. in the ROM file.
+package cdl
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+var (
+	// BizHawk's flags. ExecFirst marks the first byte of an instruction and
+	// ExecOperand the rest.
+	EXEC_FIRST   uint8 = 0x01
+	EXEC_OPERAND uint8 = 0x02
+	DATA         uint8 = 0x04
+	CODE               = EXEC_FIRST | EXEC_OPERAND
+
+	// GFX marks bytes copied to VRAM by DMA, which are data as well. It is
+	// GameFella's own, BizHawk leaves bit 3 unused.
+	GFX uint8 = 0x08
+
+	ROM      = 0
+	WRAM     = 1
+	HRAM     = 2
+	CART_RAM = 3
+
+	BLOCK_NAMES = [4]string{"ROM", "WRAM", "HRAM", "CartRAM"}
+
+	HEADER   = "BIZHAWK-CDL-2"
+	SUB_TYPE = "GB"
+
+	BANK_SIZE      = 0x4000
+	HRAM_SIZE      = 0x80
+	MAX_BLOCK_SIZE = 8 << 20
+
+	ErrHeader = errors.New("not a BizHawk GB code/data log")
+)
+
+// Log holds the flags of each block. A cartridge without RAM has an empty
+// CartRAM block, which is not saved.
+type Log struct {
+	Blocks [4][]uint8
+}
+
+// New returns an empty log for the given sizes of ROM, WRAM and cartridge
+// RAM.
+func New(rom, wram, cartRAM int) *Log {
+	l := &Log{}
+	l.Blocks[ROM] = make([]uint8, rom)
+	l.Blocks[WRAM] = make([]uint8, wram)
+	l.Blocks[HRAM] = make([]uint8, HRAM_SIZE)
+	l.Blocks[CART_RAM] = make([]uint8, cartRAM)
+	return l
+}
+
+func Load(filename string) (*Log, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return Read(bytes.NewReader(data))
+}
+
+// Read reads a log. Blocks missing from it are left empty.
+func Read(r io.Reader) (*Log, error) {
+	br := bufio.NewReader(r)
+	if header, err := readString(br); err != nil || header != HEADER {
+		return nil, ErrHeader
+	}
+	subType, err := readString(br)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimRight(subType, " ") != SUB_TYPE {
+		return nil, fmt.Errorf("code/data log is for %q, not GB", strings.TrimRight(subType, " "))
+	}
+	var count int32
+	if err := binary.Read(br, binary.LittleEndian, &count); err != nil {
+		return nil, err
+	}
+
+	l := &Log{}
+	for i := int32(0); i < count; i++ {
+		name, err := readString(br)
+		if err != nil {
+			return nil, err
+		}
+		block := blockIndex(name)
+		if block < 0 {
+			return nil, fmt.Errorf("unknown code/data log block %q", name)
+		}
+		var size int32
+		if err := binary.Read(br, binary.LittleEndian, &size); err != nil {
+			return nil, err
+		}
+		if size < 0 || size > int32(MAX_BLOCK_SIZE) {
+			return nil, fmt.Errorf("code/data log block %s has size %d", name, size)
+		}
+		l.Blocks[block] = make([]uint8, size)
+		if _, err := io.ReadFull(br, l.Blocks[block]); err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+func (l *Log) Save(filename string) error {
+	var buf bytes.Buffer
+	if err := l.Write(&buf); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, buf.Bytes(), 0644)
+}
+
+func (l *Log) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	writeString(bw, HEADER)
+	writeString(bw, fmt.Sprintf("%-15s", SUB_TYPE))
+	count := int32(0)
+	for _, flags := range l.Blocks {
+		if len(flags) > 0 {
+			count++
+		}
+	}
+	binary.Write(bw, binary.LittleEndian, count)
+	for i, flags := range l.Blocks {
+		if len(flags) == 0 {
+			continue
+		}
+		writeString(bw, BLOCK_NAMES[i])
+		binary.Write(bw, binary.LittleEndian, int32(len(flags)))
+		bw.Write(flags)
+	}
+	return bw.Flush()
+}
+
+// Strings are written like .NET's BinaryWriter does, after their length in
+// 7-bit groups, which is an unsigned varint.
+func writeString(w *bufio.Writer, s string) {
+	var buf [binary.MaxVarintLen32]uint8
+	w.Write(buf[:binary.PutUvarint(buf[:], uint64(len(s)))])
+	w.WriteString(s)
+}
+
+func readString(r *bufio.Reader) (string, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", err
+	}
+	if n > 256 {
+		return "", fmt.Errorf("code/data log string of length %d", n)
+	}
+	buf := make([]uint8, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+func blockIndex(name string) int {
+	for i, n := range BLOCK_NAMES {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Mark sets flags on the byte at offset in a block. Offsets past the end of
+// the block wrap around, like bank numbers larger than the ROM do.
+func (l *Log) Mark(block, offset int, flags uint8) {
+	if len(l.Blocks[block]) == 0 {
+		return
+	}
+	l.Blocks[block][offset%len(l.Blocks[block])] |= flags
+}
+
+// Merge adds the flags of other, which must be a log of the same ROM. Blocks
+// that other does not have are skipped.
+func (l *Log) Merge(other *Log) error {
+	for i, flags := range other.Blocks {
+		if len(flags) > 0 && len(flags) != len(l.Blocks[i]) {
+			return fmt.Errorf("CDL %s size %d does not match %d", BLOCK_NAMES[i], len(flags), len(l.Blocks[i]))
+		}
+	}
+	for i, flags := range other.Blocks {
+		for j, f := range flags {
+			l.Blocks[i][j] |= f
+		}
+	}
+	return nil
+}
+
+// Coverage counts the bytes of a ROM bank with each flag. A byte can be both
+// code and data, so Used counts the bytes with any flag.
+type Coverage struct {
+	Bank                        int
+	Code, Data, Gfx, Used, Size int
+}
+
+func (l *Log) Coverage() []Coverage {
+	var banks []Coverage
+	rom := l.Blocks[ROM]
+	for start := 0; start < len(rom); start += BANK_SIZE {
+		end := start + BANK_SIZE
+		if end > len(rom) {
+			end = len(rom)
+		}
+		c := Coverage{Bank: start / BANK_SIZE, Size: end - start}
+		for _, f := range rom[start:end] {
+			if f&CODE != 0 {
+				c.Code++
+			}
+			if f&DATA != 0 {
+				c.Data++
+			}
+			if f&GFX != 0 {
+				c.Gfx++
+			}
+			if f != 0 {
+				c.Used++
+			}
+		}
+		banks = append(banks, c)
+	}
+	return banks
+}
+
+// WriteSummary writes the coverage of every ROM bank and of the whole ROM.
+func (l *Log) WriteSummary(w io.Writer) {
+	fmt.Fprintf(w, "%5s %7s %7s %7s %7s %7s\n", "bank", "code", "data", "gfx", "unused", "used")
+	total := Coverage{}
+	for _, c := range l.Coverage() {
+		writeCoverage(w, fmt.Sprintf("%02X", c.Bank), c)
+		total.Code += c.Code
+		total.Data += c.Data
+		total.Gfx += c.Gfx
+		total.Used += c.Used
+		total.Size += c.Size
+	}
+	writeCoverage(w, "total", total)
+}
+
+func writeCoverage(w io.Writer, name string, c Coverage) {
+	used := 0.0
+	if c.Size > 0 {
+		used = float64(c.Used) * 100 / float64(c.Size)
+	}
+	fmt.Fprintf(w, "%5s %7d %7d %7d %7d %6.1f%%\n", name, c.Code, c.Data, c.Gfx, c.Size-c.Used, used)
+}
@@ -0,0 +1,126 @@
+package cdl
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+)
+
+// bizhawkLog is a log as BizHawk writes it, with a 4-byte ROM, a 2-byte WRAM
+// and no cartridge RAM.
+var bizhawkLog = []uint8{
+	13, 'B', 'I', 'Z', 'H', 'A', 'W', 'K', '-', 'C', 'D', 'L', '-', '2',
+	15, 'G', 'B', ' ', ' ', ' ', ' ', ' ', ' ', ' ', ' ', ' ', ' ', ' ', ' ', ' ',
+	2, 0, 0, 0,
+	3, 'R', 'O', 'M', 4, 0, 0, 0, 0x01, 0x02, 0x04, 0x00,
+	4, 'W', 'R', 'A', 'M', 2, 0, 0, 0, 0x00, 0x05,
+}
+
+func TestWrite(t *testing.T) {
+	l := &Log{}
+	l.Blocks[ROM] = []uint8{EXEC_FIRST, EXEC_OPERAND, DATA, 0}
+	l.Blocks[WRAM] = []uint8{0, EXEC_FIRST | DATA}
+	var buf bytes.Buffer
+	if err := l.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), bizhawkLog) {
+		t.Errorf("wrote % X, want % X", buf.Bytes(), bizhawkLog)
+	}
+}
+
+func TestRead(t *testing.T) {
+	l, err := Read(bytes.NewReader(bizhawkLog))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [4][]uint8{{0x01, 0x02, 0x04, 0x00}, {0x00, 0x05}, nil, nil}
+	for i := range want {
+		if !bytes.Equal(l.Blocks[i], want[i]) {
+			t.Errorf("%s is % X, want % X", BLOCK_NAMES[i], l.Blocks[i], want[i])
+		}
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data []uint8
+	}{
+		{"empty", nil},
+		{"old header", append([]uint8{13}, "BIZHAWK-CDL-1"...)},
+		{"raw flags", []uint8{0x01, 0x02, 0x04}},
+		{"other system", bytes.Replace(bizhawkLog, []uint8("GB "), []uint8("NES"), 1)},
+		{"unknown block", bytes.Replace(bizhawkLog, []uint8("WRAM"), []uint8("VRAM"), 1)},
+		{"truncated", bizhawkLog[:len(bizhawkLog)-1]},
+	}
+	for _, test := range tests {
+		if _, err := Read(bytes.NewReader(test.data)); err == nil {
+			t.Errorf("%s: read an invalid log", test.name)
+		}
+	}
+}
+
+func TestSaveLoadMerge(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "game.cdl")
+	a := New(0x8000, 0x2000, 0x2000)
+	a.Mark(ROM, 0x150, EXEC_FIRST)
+	a.Mark(ROM, 0x151, EXEC_OPERAND)
+	a.Mark(CART_RAM, 0x10, DATA)
+	if err := a.Save(filename); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Load(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range a.Blocks {
+		if !bytes.Equal(loaded.Blocks[i], a.Blocks[i]) {
+			t.Errorf("%s changed when saved and loaded", BLOCK_NAMES[i])
+		}
+	}
+
+	b := New(0x8000, 0x2000, 0x2000)
+	b.Mark(ROM, 0x150, DATA)
+	b.Mark(WRAM, 0x1000, EXEC_FIRST)
+	b.Mark(HRAM, 0x7F, DATA)
+	if err := b.Merge(loaded); err != nil {
+		t.Fatal(err)
+	}
+	marks := []struct {
+		block, offset int
+		flags         uint8
+	}{
+		{ROM, 0x150, EXEC_FIRST | DATA},
+		{ROM, 0x151, EXEC_OPERAND},
+		{WRAM, 0x1000, EXEC_FIRST},
+		{HRAM, 0x7F, DATA},
+		{CART_RAM, 0x10, DATA},
+	}
+	for _, m := range marks {
+		if got := b.Blocks[m.block][m.offset]; got != m.flags {
+			t.Errorf("%s %X has flags %02X, want %02X", BLOCK_NAMES[m.block], m.offset, got, m.flags)
+		}
+	}
+	if cov := b.Coverage()[0]; cov.Code != 2 || cov.Data != 1 || cov.Used != 2 {
+		t.Errorf("bank 0 coverage is %+v", cov)
+	}
+
+	// A log without cartridge RAM, like BizHawk writes for such games, merges
+	// into the other blocks.
+	if err := b.Merge(New(0x8000, 0x2000, 0)); err != nil {
+		t.Error(err)
+	}
+	if err := b.Merge(New(0x4000, 0x2000, 0x2000)); err == nil {
+		t.Error("merged the log of a smaller ROM")
+	}
+}
+
+func TestMarkEmpty(t *testing.T) {
+	l := New(0x8000, 0x2000, 0)
+	l.Mark(CART_RAM, 0x10, DATA)
+	l.Mark(ROM, 0x8000+0x150, EXEC_FIRST)
+	if l.Blocks[ROM][0x150] != EXEC_FIRST {
+		t.Error("offsets past the end of the ROM do not wrap")
+	}
+}
@@ -0,0 +1,47 @@
+package emu
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/is386/GoBoy/emu/cdl"
+)
+
+func TestCodeDataLogger(t *testing.T) {
+	gb := newTestGameBoy(t, []uint8{
+		0xFA, 0x23, 0xD1, // LD A,($D123)
+		0xF0, 0x90, // LDH A,($90)
+		0xC3, 0x00, 0xE1, // JP $E100
+	}, false)
+	copy(gb.mmu.WRAM0[0x100:], []uint8{0x18, 0xFE}) // JR -2, at C100 and its echo
+	if err := gb.StartCDL(filepath.Join(t.TempDir(), "test.cdl")); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 6; i++ {
+		gb.step()
+	}
+
+	log := gb.CDL()
+	tests := []struct {
+		block, offset int
+		flags         uint8
+	}{
+		{cdl.ROM, 0x150, cdl.EXEC_FIRST},
+		{cdl.ROM, 0x151, cdl.EXEC_OPERAND},
+		{cdl.ROM, 0x152, cdl.EXEC_OPERAND},
+		{cdl.ROM, 0x153, cdl.EXEC_FIRST},
+		{cdl.ROM, 0x155, cdl.EXEC_FIRST},
+		{cdl.WRAM, 0x1123, cdl.DATA},
+		{cdl.HRAM, 0x10, cdl.DATA},
+		{cdl.WRAM, 0x100, cdl.EXEC_FIRST},
+		{cdl.WRAM, 0x101, cdl.EXEC_OPERAND},
+	}
+	for _, test := range tests {
+		if got := log.Blocks[test.block][test.offset]; got != test.flags {
+			t.Errorf("%s %04X has flags %02X, want %02X", cdl.BLOCK_NAMES[test.block], test.offset, got, test.flags)
+		}
+	}
+	if n := len(log.Blocks[cdl.CART_RAM]); n != 0 {
+		t.Errorf("cartridge RAM block of %d bytes without cartridge RAM", n)
+	}
+}
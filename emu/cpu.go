@@ -1,9 +1,8 @@
 package emu
 
 import (
-	"fmt"
-
 	"github.com/is386/GoBoy/emu/bits"
+	"github.com/is386/GoBoy/emu/state"
 )
 
 var (
@@ -13,41 +12,102 @@ var (
 	INT_TIMER         = 2
 	INT_SERIAL        = 3
 	INT_JOYPAD        = 4
-	INT_ADDR          = map[int]uint16{
-		INT_VBLANK: 0x40,
-		INT_LCD:    0x48,
-		INT_TIMER:  0x50,
-		INT_SERIAL: 0x58,
-		INT_JOYPAD: 0x60,
-	}
+	INT_ADDR          = [5]uint16{0x40, 0x48, 0x50, 0x58, 0x60}
+
+	// SPEED_SWITCH_CYCLES is how many M-cycles the CPU pauses for when STOP
+	// switches the CGB speed.
+	SPEED_SWITCH_CYCLES = 2050
 )
 
+// Bus is the CPU's view of the rest of the machine: memory, the clock that
+// runs everything else, and the interrupt lines. The MMU is the bus of a
+// GameBoy, but the CPU can run on any other, e.g. a flat test memory.
+type Bus interface {
+	// tick runs the machine for one M-cycle and returns the clocks it took.
+	tick() int
+	cpuRead(addr uint16) uint8
+	cpuWrite(addr uint16, val uint8)
+	// cpuFetch reads an instruction byte.
+	cpuFetch(addr uint16) uint8
+	// interrupts returns the requested interrupts that are enabled.
+	interrupts() uint8
+	// acknowledge clears the request of interrupt i once it is dispatched.
+	acknowledge(i int)
+	// joypadLow reports whether a selected button is pressed.
+	joypadLow() bool
+	// stop resets DIV for STOP, and switches the CGB speed if KEY1 asks for
+	// it. It returns true if the speed was switched.
+	stop() bool
+	// lockup runs when the CPU locks up on an illegal opcode.
+	lockup()
+}
+
 type CPU struct {
-	gb                      *GameBoy
+	bus                     Bus
+	calls                   *CallStack
 	reg                     *Registers
 	flags                   *Flags
 	pc, sp                  uint16
 	halted, ime, imePending bool
+	locked, haltBug         bool
+	stopped                 bool
+	stall                   int
+
+	// M-cycles and clocks spent by the instruction being executed.
+	ticks, cycles int
 }
 
 func NewCPU(gb *GameBoy, isCGB bool, bootEnabled bool) *CPU {
-	c := &CPU{gb: gb, reg: NewRegisters(isCGB), flags: NewFlags(), sp: SP}
+	c := &CPU{bus: gb.mmu, calls: gb.calls, reg: NewRegisters(isCGB), flags: NewFlags(), sp: SP}
 	if !bootEnabled {
 		c.pc = 0x100
 	}
 	return c
 }
 
+func (c *CPU) serialize(s state.Stream) {
+	s.Uint8(&c.reg.A)
+	s.Uint8(&c.reg.B)
+	s.Uint8(&c.reg.C)
+	s.Uint8(&c.reg.D)
+	s.Uint8(&c.reg.E)
+	s.Uint8(&c.reg.H)
+	s.Uint8(&c.reg.L)
+	s.Uint8(&c.flags.Z)
+	s.Uint8(&c.flags.N)
+	s.Uint8(&c.flags.H)
+	s.Uint8(&c.flags.C)
+	s.Uint16(&c.pc)
+	s.Uint16(&c.sp)
+	s.Bool(&c.halted)
+	s.Bool(&c.ime)
+	s.Bool(&c.imePending)
+	s.Bool(&c.locked)
+	s.Bool(&c.haltBug)
+	s.Bool(&c.stopped)
+	s.Int(&c.stall)
+}
+
+// tick runs the rest of the machine for one M-cycle. Every memory access of
+// the CPU takes one, so the PPU, timer and APU see each access at the right
+// point of an instruction.
+func (c *CPU) tick() {
+	c.ticks++
+	c.cycles += c.bus.tick()
+}
+
 func (c *CPU) readByte(addr uint16) uint8 {
-	return c.gb.mmu.readByte(addr)
+	c.tick()
+	return c.bus.cpuRead(addr)
 }
 
 func (c *CPU) readByteHL() uint8 {
-	return c.gb.mmu.readByte(c.reg.getHL())
+	return c.readByte(c.reg.getHL())
 }
 
 func (c *CPU) writeByte(addr uint16, val uint8) {
-	c.gb.mmu.writeByte(addr, val)
+	c.tick()
+	c.bus.cpuWrite(addr, val)
 }
 
 func (c *CPU) writeByteHL(val uint8) {
@@ -55,7 +115,8 @@ func (c *CPU) writeByteHL(val uint8) {
 }
 
 func (c *CPU) nextByte() uint8 {
-	val := c.readByte(c.pc)
+	c.tick()
+	val := c.bus.cpuFetch(c.pc)
 	c.pc++
 	return val
 }
@@ -67,6 +128,14 @@ func (c *CPU) nextTwoBytes() uint16 {
 }
 
 func (c *CPU) fetch() uint8 {
+	if c.haltBug {
+		// The PC fails to increment after the HALT bug, so the byte after
+		// HALT is read twice.
+		c.haltBug = false
+		val := c.nextByte()
+		c.pc--
+		return val
+	}
 	return c.nextByte()
 }
 
@@ -78,57 +147,99 @@ func (c *CPU) decode(opcode uint8) (func(*CPU), int) {
 	return INSTRUCTIONS[opcode], CYCLES[opcode]
 }
 
-func (c *CPU) execute(speed int) int {
+// execute runs one instruction and returns the number of clocks it took.
+func (c *CPU) execute() int {
+	c.ticks, c.cycles = 0, 0
+	enableIME := c.imePending
 	opcode := c.fetch()
 	instr, cyc := c.decode(opcode)
 	instr(c)
-	return (cyc * 4) / speed
+
+	// EI sets IME after the instruction that follows it, unless that
+	// instruction was DI.
+	if enableIME && c.imePending {
+		c.ime = true
+		c.imePending = false
+	}
+
+	// Internal cycles that come after the last memory access.
+	for c.ticks < cyc {
+		c.tick()
+	}
+	return c.cycles
 }
 
-func (c *CPU) print() {
-	// fmt.Printf("A: %02X F: %02X B: %02X C: %02X D: %02X E: %02X H: %02X L: %02X SP: %04X PC: 00:%04X (%02X %02X %02X %02X)\n",
-	// c.reg.A, c.flags.getF(), c.reg.B, c.reg.C, c.reg.D, c.reg.E, c.reg.H, c.reg.L,
-	// c.sp, c.pc, c.readByte(c.pc), c.readByte(c.pc+1), c.readByte(c.pc+2), c.readByte(c.pc+3))
-	fmt.Printf("AF: %04X BC: %04X DE: %04X HL: %04X SP: %04X PC: %04X ROM: %02d (%02X %02X %02X %02X)\n",
-		c.reg.getAF(c.flags.getF()), c.reg.getBC(), c.reg.getDE(), c.reg.getHL(),
-		c.sp, c.pc, c.gb.cart.GetRomBank(), c.readByte(c.pc), c.readByte(c.pc+1), c.readByte(c.pc+2), c.readByte(c.pc+3))
-	fmt.Scanln()
+func (c *CPU) setF(psw uint8) {
+	c.flags.Z = (psw >> 7) & 1
+	c.flags.N = (psw >> 6) & 1
+	c.flags.H = (psw >> 5) & 1
+	c.flags.C = (psw >> 4) & 1
 }
 
+// parked reports whether the CPU waits at the same PC without executing,
+// because it is locked up, halted, stopped or switching speed.
+func (c *CPU) parked() bool {
+	return c.locked || c.halted || c.stopped || c.stall > 0
+}
+
+// checkIME runs between instructions. A pending interrupt wakes the CPU
+// from HALT, and is dispatched if IME is set.
 func (c *CPU) checkIME() {
-	if c.imePending {
-		c.ime = true
-		c.imePending = false
+	if c.locked || c.stopped || c.stall > 0 || c.pendingInterrupt() < 0 {
 		return
 	}
-
-	if !c.ime && !c.halted {
-		return
+	if c.halted {
+		// Leaving HALT takes an extra cycle.
+		c.halted = false
+		c.tick()
 	}
+	if c.ime {
+		c.doInterrupt()
+	}
+}
 
-	intF := c.readByte(0xFF0F) | 0xE0
-	intE := c.readByte(0xFFFF)
-	if intF > 0 {
-		for i := 0; i < 5; i++ {
-			if (((intF >> i) & 1) == 1) && (((intE >> i) & 1) == 1) {
-				c.doInterrupt(i)
-				return
-			}
+// pendingInterrupt returns the enabled and requested interrupt with the
+// highest priority, or -1.
+func (c *CPU) pendingInterrupt() int {
+	pending := c.bus.interrupts()
+	for i := 0; i < 5; i++ {
+		if (pending>>i)&1 == 1 {
+			return i
 		}
 	}
+	return -1
 }
 
-func (c *CPU) doInterrupt(i int) {
-	if !c.ime && c.halted {
-		c.halted = false
+// doInterrupt takes 5 M-cycles: two wait states, the push of the PC and the
+// jump. The interrupt is chosen after the high byte of the PC is pushed, so
+// a push that overwrites IE can change it, or cancel it and jump to 0000.
+func (c *CPU) doInterrupt() {
+	c.ime = false
+	ret := c.pc
+	if c.haltBug {
+		// The interrupt returns to the HALT, which runs again.
+		c.haltBug = false
+		ret--
+	}
+
+	c.tick()
+	c.tick()
+	c.sp--
+	c.writeByte(c.sp, uint8(ret>>8))
+	i := c.pendingInterrupt()
+	c.sp--
+	c.writeByte(c.sp, uint8(ret&0xff))
+	c.tick()
+
+	if i < 0 {
+		c.pc = 0
 		return
 	}
-	c.ime = false
-	c.halted = false
-	intF := c.readByte(0xFF0F)
-	c.writeByte(0xFF0F, c.res(intF, uint8(i)))
-	c.push(c.pc)
+	c.bus.acknowledge(i)
 	c.pc = INT_ADDR[i]
+	if c.calls != nil {
+		c.calls.interrupt(i, ret)
+	}
 }
 
 func flip(val uint8) uint8 {
@@ -242,23 +353,48 @@ func (c *CPU) set(val uint8, u3 uint8) uint8 {
 
 func (c *CPU) jump(addr uint16, cond bool) {
 	if cond {
+		c.tick()
 		c.pc = addr
 	}
 }
 
 func (c *CPU) call(addr uint16, cond bool) {
 	if cond {
-		c.push(c.pc)
+		ret := c.pc
+		c.push(ret)
 		c.pc = addr
+		if c.calls != nil {
+			c.calls.call(FRAME_CALL, addr, ret)
+		}
+	}
+}
+
+func (c *CPU) rst(addr uint16) {
+	ret := c.pc
+	c.push(ret)
+	c.pc = addr
+	if c.calls != nil {
+		c.calls.call(FRAME_RST, addr, ret)
 	}
 }
 
 func (c *CPU) ret(cond bool) {
 	if cond {
+		sp := c.sp
 		c.pc = c.pop()
+		c.tick()
+		if c.calls != nil {
+			c.calls.ret(sp, c.pc)
+		}
 	}
 }
 
+// retIf takes an extra cycle to check cond.
+func (c *CPU) retIf(cond bool) {
+	c.tick()
+	c.ret(cond)
+}
+
 func (c *CPU) rotateLeft(val uint8) uint8 {
 	cy := val >> 7
 	ans := (val << 1) | c.flags.C
@@ -334,14 +470,17 @@ func (c *CPU) swap(val uint8) uint8 {
 }
 
 func (c *CPU) push(val uint16) {
+	c.tick()
 	c.writeByte(c.sp-1, uint8(val>>8))
 	c.writeByte(c.sp-2, uint8(val&0xff))
 	c.sp -= 2
 }
 
 func (c *CPU) pop() uint16 {
+	lo := c.readByte(c.sp)
+	hi := c.readByte(c.sp + 1)
 	c.sp += 2
-	return ((uint16(c.readByte(c.sp-1)) << 8) | uint16(c.readByte(c.sp-2)))
+	return uint16(hi)<<8 | uint16(lo)
 }
 
 func nop(c *CPU) {
@@ -1952,7 +2091,7 @@ func jpnc(c *CPU) {
 }
 
 func jpHL(c *CPU) {
-	c.jump(c.reg.getHL(), true)
+	c.pc = c.reg.getHL()
 }
 
 func jr(c *CPU) {
@@ -2001,35 +2140,35 @@ func callnc(c *CPU) {
 }
 
 func rst0(c *CPU) {
-	c.call(0x00, true)
+	c.rst(0x00)
 }
 
 func rst8(c *CPU) {
-	c.call(0x08, true)
+	c.rst(0x08)
 }
 
 func rst10(c *CPU) {
-	c.call(0x10, true)
+	c.rst(0x10)
 }
 
 func rst18(c *CPU) {
-	c.call(0x18, true)
+	c.rst(0x18)
 }
 
 func rst20(c *CPU) {
-	c.call(0x20, true)
+	c.rst(0x20)
 }
 
 func rst28(c *CPU) {
-	c.call(0x28, true)
+	c.rst(0x28)
 }
 
 func rst30(c *CPU) {
-	c.call(0x30, true)
+	c.rst(0x30)
 }
 
 func rst38(c *CPU) {
-	c.call(0x38, true)
+	c.rst(0x38)
 }
 
 func ret(c *CPU) {
@@ -2037,19 +2176,19 @@ func ret(c *CPU) {
 }
 
 func retz(c *CPU) {
-	c.ret(c.flags.Z == 1)
+	c.retIf(c.flags.Z == 1)
 }
 
 func retnz(c *CPU) {
-	c.ret(c.flags.Z == 0)
+	c.retIf(c.flags.Z == 0)
 }
 
 func retc(c *CPU) {
-	c.ret(c.flags.C == 1)
+	c.retIf(c.flags.C == 1)
 }
 
 func retnc(c *CPU) {
-	c.ret(c.flags.C == 0)
+	c.retIf(c.flags.C == 0)
 }
 
 func reti(c *CPU) {
@@ -2060,11 +2199,7 @@ func reti(c *CPU) {
 func popAF(c *CPU) {
 	af := c.pop()
 	c.reg.A = uint8(af >> 8)
-	psw := uint8(af & 0xff)
-	c.flags.Z = (psw >> 7) & 1
-	c.flags.N = (psw >> 6) & 1
-	c.flags.H = (psw >> 5) & 1
-	c.flags.C = (psw >> 4) & 1
+	c.setF(uint8(af & 0xff))
 }
 
 func popBC(c *CPU) {
@@ -2370,6 +2505,7 @@ func daa(c *CPU) {
 
 func di(c *CPU) {
 	c.ime = false
+	c.imePending = false
 }
 
 func ei(c *CPU) {
@@ -2388,14 +2524,38 @@ func ccf(c *CPU) {
 	c.flags.C = flip(c.flags.C)
 }
 
+// halt stops the CPU until an interrupt is pending. If one already is and
+// IME is not set, the CPU does not halt and the HALT bug reads the next
+// byte twice instead.
 func halt(c *CPU) {
+	if !c.ime && c.pendingInterrupt() >= 0 {
+		c.haltBug = true
+		return
+	}
 	c.halted = true
 }
 
+// stop follows the STOP flowchart of the Pan Docs. Depending on the joypad,
+// pending interrupts and KEY1 it enters STOP mode, switches the CGB speed or
+// only halts. STOP skips the byte after it unless an interrupt is pending.
+// With IME set, a speed switch with an interrupt pending glitches the CPU on
+// hardware; here it switches like it does with IME clear.
 func stop(c *CPU) {
-	c.halted = true
-	if c.gb.isCGB {
-		c.gb.changeSpeed()
+	pending := c.pendingInterrupt() >= 0
+	if c.bus.joypadLow() {
+		if !pending {
+			c.nextByte()
+			c.halted = true
+		}
+		return
+	}
+
+	if !pending {
+		c.nextByte()
+	}
+	if c.bus.stop() {
+		c.stall = SPEED_SWITCH_CYCLES
+		return
 	}
-	c.fetch()
+	c.stopped = true
 }
@@ -0,0 +1,312 @@
+package emu
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// SM83_MAX_FAILURES is the number of failing tests reported per opcode.
+var SM83_MAX_FAILURES = 5
+
+// sm83State is a CPU and memory state of the SM83 single-step tests.
+type sm83State struct {
+	A, B, C, D, E, F, H, L uint8
+	PC                     uint16
+	SP                     uint16
+	IME                    uint8
+	EI                     *uint8
+	RAM                    [][2]uint16
+}
+
+// sm83Test runs one instruction from Initial to Final. Each entry of Cycles
+// is an M-cycle: [addr, val, "r-m"] for a read, [addr, val, "-wm"] for a
+// write, and null or [addr, val, "---"] for an internal cycle.
+type sm83Test struct {
+	Name    string
+	Initial sm83State
+	Final   sm83State
+	Cycles  [][]interface{}
+}
+
+type busCycle struct {
+	addr uint16
+	val  uint8
+	kind string
+}
+
+func (bc busCycle) String() string {
+	if bc.kind == "---" {
+		return "---"
+	}
+	return fmt.Sprintf("%04X %02X %s", bc.addr, bc.val, bc.kind)
+}
+
+// testBus is a flat 64 KiB memory that records the activity of every cycle.
+type testBus struct {
+	mem     [0x10000]uint8
+	cycles  []busCycle
+	lockups int
+}
+
+func (b *testBus) tick() int {
+	b.cycles = append(b.cycles, busCycle{kind: "---"})
+	return 4
+}
+
+func (b *testBus) cpuRead(addr uint16) uint8 {
+	val := b.mem[addr]
+	b.cycles[len(b.cycles)-1] = busCycle{addr, val, "r-m"}
+	return val
+}
+
+func (b *testBus) cpuWrite(addr uint16, val uint8) {
+	b.mem[addr] = val
+	b.cycles[len(b.cycles)-1] = busCycle{addr, val, "-wm"}
+}
+
+func (b *testBus) cpuFetch(addr uint16) uint8 {
+	return b.cpuRead(addr)
+}
+
+func (b *testBus) interrupts() uint8 {
+	return b.mem[0xFF0F] & b.mem[0xFFFF] & 0x1F
+}
+
+func (b *testBus) acknowledge(i int) {
+	b.mem[0xFF0F] &^= 1 << i
+}
+
+// The joypad, DIV and KEY1 are not on the flat bus: STOP always stops the
+// clock.
+func (b *testBus) joypadLow() bool {
+	return false
+}
+
+func (b *testBus) stop() bool {
+	return false
+}
+
+func (b *testBus) lockup() {
+	b.lockups++
+}
+
+// newBusCPU returns a CPU on bus, without the rest of the machine.
+func newBusCPU(bus *testBus) *CPU {
+	return &CPU{bus: bus, reg: NewRegisters(false), flags: NewFlags()}
+}
+
+// stepCPU runs one instruction, or one M-cycle while halted or locked up, and
+// dispatches any interrupt, like GameBoy.step.
+func stepCPU(c *CPU) {
+	if c.halted || c.locked {
+		c.tick()
+	} else {
+		c.execute()
+	}
+	c.checkIME()
+}
+
+// TestSM83 runs the SM83 single-step tests from the directory in SM83_TESTS,
+// e.g. a checkout of https://github.com/SingleStepTests/sm83 at v1/, or the
+// hand-written ones in testdata/sm83. Each file holds the tests of one
+// opcode.
+func TestSM83(t *testing.T) {
+	dir := os.Getenv("SM83_TESTS")
+	if dir == "" {
+		dir = filepath.Join("testdata", "sm83")
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatalf("no tests in %s", dir)
+	}
+
+	for _, file := range files {
+		file := file
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			runSM83File(t, file)
+		})
+	}
+}
+
+func runSM83File(t *testing.T, file string) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tests []sm83Test
+	if err := json.Unmarshal(data, &tests); err != nil {
+		t.Fatal(err)
+	}
+
+	bus := &testBus{}
+	failures := 0
+	for _, test := range tests {
+		for _, m := range test.Initial.RAM {
+			bus.mem[m[0]] = uint8(m[1])
+		}
+
+		if err := runSM83Test(bus, test); err != nil {
+			t.Errorf("%s: %s", test.Name, err)
+			failures++
+			if failures == SM83_MAX_FAILURES {
+				t.Fatal("too many failures")
+			}
+		}
+
+		for _, m := range test.Initial.RAM {
+			bus.mem[m[0]] = 0
+		}
+		for _, bc := range bus.cycles {
+			bus.mem[bc.addr] = 0
+		}
+	}
+}
+
+func runSM83Test(bus *testBus, test sm83Test) error {
+	c := newBusCPU(bus)
+	in := test.Initial
+	c.reg.A, c.reg.B, c.reg.C, c.reg.D = in.A, in.B, in.C, in.D
+	c.reg.E, c.reg.H, c.reg.L = in.E, in.H, in.L
+	c.setF(in.F)
+	c.pc, c.sp = in.PC, in.SP
+	c.ime = in.IME == 1
+	c.imePending = in.EI != nil && *in.EI == 1
+
+	bus.cycles = bus.cycles[:0]
+	c.execute()
+
+	out := test.Final
+	got := fmt.Sprintf("A=%02X F=%02X B=%02X C=%02X D=%02X E=%02X H=%02X L=%02X PC=%04X SP=%04X IME=%t",
+		c.reg.A, c.flags.getF(), c.reg.B, c.reg.C, c.reg.D, c.reg.E, c.reg.H, c.reg.L, c.pc, c.sp, c.ime)
+	want := fmt.Sprintf("A=%02X F=%02X B=%02X C=%02X D=%02X E=%02X H=%02X L=%02X PC=%04X SP=%04X IME=%t",
+		out.A, out.F, out.B, out.C, out.D, out.E, out.H, out.L, out.PC, out.SP, out.IME == 1)
+	if got != want {
+		return fmt.Errorf("registers are\n  %s, want\n  %s", got, want)
+	}
+	if out.EI != nil && c.imePending != (*out.EI == 1) {
+		return fmt.Errorf("EI pending is %t, want %t", c.imePending, *out.EI == 1)
+	}
+
+	for _, m := range out.RAM {
+		if bus.mem[m[0]] != uint8(m[1]) {
+			return fmt.Errorf("memory at %04X is %02X, want %02X", m[0], bus.mem[m[0]], m[1])
+		}
+	}
+
+	if len(bus.cycles) != len(test.Cycles) {
+		return fmt.Errorf("took %d cycles %v, want %d", len(bus.cycles), bus.cycles, len(test.Cycles))
+	}
+	for i, cycle := range test.Cycles {
+		want := busCycle{kind: "---"}
+		if len(cycle) == 3 && cycle[2] != "---" {
+			addr, _ := cycle[0].(float64)
+			val, _ := cycle[1].(float64)
+			kind, _ := cycle[2].(string)
+			want = busCycle{uint16(addr), uint8(val), kind}
+		}
+		if bus.cycles[i] != want {
+			return fmt.Errorf("cycle %d is %s, want %s", i, bus.cycles[i], want)
+		}
+	}
+	return nil
+}
+
+func TestHaltBug(t *testing.T) {
+	// With IME clear and an interrupt pending, HALT does not halt and the
+	// byte after it is read twice.
+	bus := &testBus{}
+	copy(bus.mem[0x0200:], []uint8{0x76, 0x3C, 0x00}) // HALT; INC A; NOP
+	bus.mem[0xFF0F], bus.mem[0xFFFF] = 0x04, 0x04
+	c := newBusCPU(bus)
+	c.reg.A, c.pc, c.sp = 0, 0x0200, 0xD000
+
+	for i := 0; i < 3; i++ {
+		stepCPU(c)
+	}
+	if c.halted || c.reg.A != 2 || c.pc != 0x0202 {
+		t.Errorf("halted %t, A=%02X, PC=%04X, want false, 02, 0202", c.halted, c.reg.A, c.pc)
+	}
+	if bus.mem[0xFF0F] != 0x04 {
+		t.Error("the interrupt was dispatched with IME clear")
+	}
+}
+
+func TestEIHalt(t *testing.T) {
+	tests := []struct {
+		name    string
+		pending bool
+		ret     uint16
+	}{
+		// The interrupt is dispatched right after HALT, and returns to the
+		// HALT, which runs again.
+		{"pending", true, 0x0201},
+		// HALT halts until the interrupt, which returns after it.
+		{"later", false, 0x0202},
+	}
+	for _, test := range tests {
+		bus := &testBus{}
+		copy(bus.mem[0x0200:], []uint8{0xFB, 0x76, 0x00}) // EI; HALT; NOP
+		bus.mem[0xFFFF] = 0x04
+		if test.pending {
+			bus.mem[0xFF0F] = 0x04
+		}
+		c := newBusCPU(bus)
+		c.pc, c.sp = 0x0200, 0xD000
+
+		stepCPU(c)
+		stepCPU(c)
+		if !test.pending {
+			if !c.halted {
+				t.Fatalf("%s: not halted", test.name)
+			}
+			bus.mem[0xFF0F] = 0x04
+			stepCPU(c)
+		}
+
+		ret := uint16(bus.mem[0xCFFF])<<8 | uint16(bus.mem[0xCFFE])
+		if c.pc != INT_ADDR[INT_TIMER] || c.sp != 0xCFFE || ret != test.ret {
+			t.Errorf("%s: PC=%04X SP=%04X return %04X, want %04X CFFE %04X",
+				test.name, c.pc, c.sp, ret, INT_ADDR[INT_TIMER], test.ret)
+		}
+		if c.ime || c.halted || bus.mem[0xFF0F] != 0 {
+			t.Errorf("%s: IME %t, halted %t, IF %02X after the dispatch", test.name, c.ime, c.halted, bus.mem[0xFF0F])
+		}
+	}
+}
+
+func TestInterruptPushToIE(t *testing.T) {
+	// With SP at 0000, the high byte of the PC is pushed to IE before the
+	// interrupt is chosen.
+	tests := []struct {
+		name   string
+		pc     uint16
+		ifReg  uint8
+		wantPC uint16
+		wantIF uint8
+	}{
+		{"cancel to 0000", 0x0200, 0x04, 0x0000, 0x04},
+		{"still enabled", 0x0400, 0x04, 0x0050, 0x00},
+		{"another interrupt", 0x0100, 0x05, 0x0040, 0x04},
+	}
+	for _, test := range tests {
+		bus := &testBus{}
+		bus.mem[0xFF0F], bus.mem[0xFFFF] = test.ifReg, 0x04
+		c := newBusCPU(bus)
+		c.pc, c.sp, c.ime = test.pc, 0x0000, true
+
+		c.checkIME()
+		if c.pc != test.wantPC || bus.mem[0xFF0F] != test.wantIF {
+			t.Errorf("%s: PC=%04X IF=%02X, want %04X %02X", test.name, c.pc, bus.mem[0xFF0F], test.wantPC, test.wantIF)
+		}
+		if c.sp != 0xFFFE || bus.mem[0xFFFF] != uint8(test.pc>>8) {
+			t.Errorf("%s: SP=%04X IE=%02X, want FFFE %02X", test.name, c.sp, bus.mem[0xFFFF], uint8(test.pc>>8))
+		}
+	}
+}
@@ -1,6 +1,9 @@
 package emu
 
-import "github.com/is386/GoBoy/emu/bits"
+import (
+	"github.com/is386/GoBoy/emu/bits"
+	"github.com/is386/GoBoy/emu/state"
+)
 
 type CRAM struct {
 	CRAM     [0x40]uint8
@@ -8,6 +11,12 @@ type CRAM struct {
 	index    uint8
 }
 
+func (c *CRAM) serialize(s state.Stream) {
+	s.Bytes(c.CRAM[:])
+	s.Bool(&c.autoIncr)
+	s.Uint8(&c.index)
+}
+
 func (c *CRAM) writeIndex(val uint8) {
 	if bits.Test(val, 7) {
 		c.autoIncr = true
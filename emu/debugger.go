@@ -0,0 +1,595 @@
+package emu
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/is386/GoBoy/emu/disasm"
+)
+
+var (
+	CALL_OPS = map[uint8]bool{0xC4: true, 0xCC: true, 0xCD: true, 0xD4: true, 0xDC: true}
+	RST_OPS  = map[uint8]bool{0xC7: true, 0xCF: true, 0xD7: true, 0xDF: true, 0xE7: true, 0xEF: true, 0xF7: true, 0xFF: true}
+)
+
+// Breakpoint stops execution at addr. A bank of -1 matches any bank.
+type Breakpoint struct {
+	bank int
+	addr uint16
+}
+
+func (b Breakpoint) String() string {
+	if b.bank < 0 {
+		return fmt.Sprintf("%04X", b.addr)
+	}
+	return fmt.Sprintf("%02X:%04X", b.bank, b.addr)
+}
+
+type Debugger struct {
+	gb             *GameBoy
+	in             *bufio.Scanner
+	out            io.Writer
+	breakpoints    []Breakpoint
+	watchpoints    []Watchpoint
+	watchHit       string
+	pc             uint16
+	steps          int
+	stepOver       *Breakpoint
+	stepOutDepth   int
+	frameTarget    uint64
+	catchReti      bool
+	catchImbalance bool
+	lockup         string
+	lastCmd        string
+	interrupt      int32
+}
+
+func NewDebugger(gb *GameBoy, in io.Reader, out io.Writer) *Debugger {
+	return &Debugger{gb: gb, in: bufio.NewScanner(in), out: out, steps: 1}
+}
+
+// BreakIntoDebugger stops at the next instruction. It is safe to call from
+// another goroutine, e.g. a SIGINT handler.
+func (gb *GameBoy) BreakIntoDebugger() {
+	if gb.debugger != nil {
+		atomic.StoreInt32(&gb.debugger.interrupt, 1)
+	}
+}
+
+// check is called before every step of the CPU and runs the REPL if execution
+// should stop there.
+func (d *Debugger) check() {
+	pc := d.gb.cpu.pc
+	reason := ""
+
+	if atomic.SwapInt32(&d.interrupt, 0) == 1 {
+		reason = "interrupted"
+	}
+	if d.steps > 0 {
+		d.steps--
+		if d.steps == 0 {
+			reason = "step"
+		}
+	}
+	if d.stepOver != nil && d.matches(*d.stepOver, pc) {
+		reason = "step over"
+	}
+	calls := d.gb.calls
+	if d.stepOutDepth > 0 && len(calls.frames) < d.stepOutDepth {
+		reason = "step out"
+	}
+	if d.catchReti && calls.returned != nil && calls.returned.Kind == FRAME_INTERRUPT {
+		reason = fmt.Sprintf("return from %s interrupt", INT_NAMES[calls.returned.Interrupt])
+	}
+	if d.catchImbalance && calls.imbalance != nil {
+		reason = "stack imbalance: " + calls.describeImbalance(calls.imbalance)
+	}
+	calls.returned, calls.imbalance = nil, nil
+	if d.frameTarget > 0 && d.gb.frames >= d.frameTarget {
+		reason = fmt.Sprintf("frame %d", d.gb.frames)
+	}
+	// A parked CPU stays at the same PC, so its breakpoints would stop it
+	// again on every cycle.
+	parked := d.gb.cpu.parked()
+	for i, b := range d.breakpoints {
+		if !parked && d.matches(b, pc) {
+			reason = fmt.Sprintf("breakpoint %d at %s", i, b)
+			if label := d.gb.describe(pc); label != "" {
+				reason += " (" + label + ")"
+			}
+		}
+	}
+
+	// Read and write watchpoints hit by the previous instruction are
+	// reported here, once it has finished.
+	d.pc = pc
+	if !parked {
+		d.checkWatchpoints(WATCH_EXEC, pc, 0, 0)
+	}
+	if d.watchHit != "" {
+		reason = d.watchHit
+		d.watchHit = ""
+	}
+	if d.lockup != "" {
+		reason = strings.TrimSuffix(d.lockup, "\n")
+		d.lockup = ""
+	}
+
+	if reason == "" {
+		return
+	}
+
+	d.steps = 0
+	d.stepOver = nil
+	d.stepOutDepth = 0
+	d.frameTarget = 0
+	fmt.Fprintf(d.out, "Stopped: %s\n", reason)
+	d.printRegisters()
+	d.repl()
+}
+
+func (d *Debugger) matches(b Breakpoint, pc uint16) bool {
+	return b.addr == pc && (b.bank < 0 || b.bank == d.gb.bankOf(pc))
+}
+
+func (d *Debugger) repl() {
+	for {
+		fmt.Fprint(d.out, "> ")
+		if !d.in.Scan() {
+			d.quit()
+			return
+		}
+
+		line := strings.TrimSpace(d.in.Text())
+		if line == "" {
+			line = d.lastCmd
+		}
+		d.lastCmd = line
+
+		args := strings.Fields(line)
+		if len(args) == 0 {
+			continue
+		}
+		if d.command(args[0], args[1:]) {
+			return
+		}
+	}
+}
+
+// command runs one REPL command and returns true if execution should resume.
+func (d *Debugger) command(cmd string, args []string) bool {
+	c := d.gb.cpu
+
+	switch cmd {
+	case "c", "continue":
+		return true
+
+	case "s", "step":
+		d.steps = 1
+		if len(args) > 0 {
+			n, err := strconv.Atoi(args[0])
+			if err != nil || n < 1 {
+				fmt.Fprintln(d.out, "usage: step [count]")
+				return false
+			}
+			d.steps = n
+		}
+		return true
+
+	case "n", "next":
+		op := d.gb.mmu.readByte(c.pc)
+		if CALL_OPS[op] || RST_OPS[op] {
+			d.stepOver = &Breakpoint{bank: d.gb.bankOf(c.pc), addr: c.pc + uint16(disasm.Length(op))}
+		} else {
+			d.steps = 1
+		}
+		return true
+
+	case "finish", "out":
+		d.stepOutDepth = len(d.gb.calls.frames)
+		if d.stepOutDepth == 0 {
+			fmt.Fprintln(d.out, "not in a call")
+			return false
+		}
+		return true
+
+	case "bt", "backtrace":
+		d.printBacktrace()
+
+	case "catch":
+		if len(args) == 0 {
+			fmt.Fprintf(d.out, "reti: %t\nimbalance: %t\n", d.catchReti, d.catchImbalance)
+			return false
+		}
+		switch args[0] {
+		case "reti":
+			d.catchReti = !d.catchReti
+			fmt.Fprintf(d.out, "stop on return from interrupt: %t\n", d.catchReti)
+		case "imbalance":
+			d.catchImbalance = !d.catchImbalance
+			fmt.Fprintf(d.out, "stop on stack imbalance: %t\n", d.catchImbalance)
+		default:
+			fmt.Fprintln(d.out, "usage: catch [reti|imbalance]")
+		}
+
+	case "frame":
+		if len(args) != 1 {
+			fmt.Fprintf(d.out, "current frame: %d\n", d.gb.frames)
+			return false
+		}
+		n, err := strconv.ParseUint(args[0], 10, 64)
+		if err != nil || n <= d.gb.frames {
+			fmt.Fprintf(d.out, "frame must be after the current frame %d\n", d.gb.frames)
+			return false
+		}
+		d.frameTarget = n
+		return true
+
+	case "b", "break":
+		if len(args) != 1 {
+			fmt.Fprintln(d.out, "usage: break label|[bank:]addr")
+			return false
+		}
+		b, err := d.gb.parseBreakpoint(args[0])
+		if err != nil {
+			fmt.Fprintln(d.out, err)
+			return false
+		}
+		d.breakpoints = append(d.breakpoints, b)
+		fmt.Fprintf(d.out, "breakpoint %d at %s\n", len(d.breakpoints)-1, b)
+
+	case "d", "delete":
+		if len(args) == 0 {
+			d.breakpoints = nil
+			return false
+		}
+		i, err := strconv.Atoi(args[0])
+		if err != nil || i < 0 || i >= len(d.breakpoints) {
+			fmt.Fprintln(d.out, "no such breakpoint")
+			return false
+		}
+		d.breakpoints = append(d.breakpoints[:i], d.breakpoints[i+1:]...)
+
+	case "bl", "breakpoints":
+		for i, b := range d.breakpoints {
+			if label := d.gb.symbols.Describe(b.bank, b.addr); label != "" {
+				fmt.Fprintf(d.out, "%d: %s (%s)\n", i, b, label)
+			} else {
+				fmt.Fprintf(d.out, "%d: %s\n", i, b)
+			}
+		}
+
+	case "wa", "watch":
+		w, err := d.parseWatchpoint(args)
+		if err != nil {
+			fmt.Fprintln(d.out, err)
+			return false
+		}
+		d.watchpoints = append(d.watchpoints, w)
+		d.updateHook()
+		fmt.Fprintf(d.out, "watchpoint %d: %s\n", len(d.watchpoints)-1, w)
+
+	case "wd", "unwatch":
+		if len(args) == 0 {
+			d.watchpoints = nil
+			d.updateHook()
+			return false
+		}
+		i, err := strconv.Atoi(args[0])
+		if err != nil || i < 0 || i >= len(d.watchpoints) {
+			fmt.Fprintln(d.out, "no such watchpoint")
+			return false
+		}
+		d.watchpoints = append(d.watchpoints[:i], d.watchpoints[i+1:]...)
+		d.updateHook()
+
+	case "wl", "watchpoints":
+		for i, w := range d.watchpoints {
+			fmt.Fprintf(d.out, "%d: %s\n", i, w)
+		}
+
+	case "r", "regs":
+		d.printRegisters()
+
+	case "set":
+		if len(args) != 2 {
+			fmt.Fprintln(d.out, "usage: set reg value")
+			return false
+		}
+		val, err := parseHex(args[1])
+		if err != nil {
+			fmt.Fprintln(d.out, err)
+			return false
+		}
+		if err := d.setRegister(args[0], val); err != nil {
+			fmt.Fprintln(d.out, err)
+		}
+
+	case "x", "examine":
+		if len(args) < 1 {
+			fmt.Fprintln(d.out, "usage: x addr [count]")
+			return false
+		}
+		addr, err := d.gb.parseAddress(args[0])
+		if err != nil {
+			fmt.Fprintln(d.out, err)
+			return false
+		}
+		n := 16
+		if len(args) > 1 {
+			if n, err = strconv.Atoi(args[1]); err != nil {
+				fmt.Fprintln(d.out, err)
+				return false
+			}
+		}
+		d.dump(addr, n)
+
+	case "w", "write":
+		if len(args) < 2 {
+			fmt.Fprintln(d.out, "usage: write addr value...")
+			return false
+		}
+		addr, err := d.gb.parseAddress(args[0])
+		if err != nil {
+			fmt.Fprintln(d.out, err)
+			return false
+		}
+		for i, arg := range args[1:] {
+			val, err := parseHex(arg)
+			if err != nil {
+				fmt.Fprintln(d.out, err)
+				return false
+			}
+			d.gb.mmu.writeByte(addr+uint16(i), uint8(val))
+		}
+
+	case "l", "list":
+		addr := c.pc
+		if len(args) > 0 {
+			val, err := d.gb.parseAddress(args[0])
+			if err != nil {
+				fmt.Fprintln(d.out, err)
+				return false
+			}
+			addr = val
+		}
+		for _, instr := range disasm.Around(d.gb.mmu.readByte, d.gb.labels(), addr, 4, 8) {
+			d.printInstruction(instr)
+		}
+
+	case "views":
+		prefix := strings.TrimSuffix(d.gb.cart.GetFileName(), filepath.Ext(d.gb.cart.GetFileName()))
+		if len(args) > 0 {
+			prefix = args[0]
+		}
+		if err := d.gb.SaveViews(prefix); err != nil {
+			fmt.Fprintln(d.out, err)
+			return false
+		}
+		fmt.Fprintf(d.out, "saved %s-{%s}.png\n", prefix, strings.Join(VIEWS, ","))
+
+	case "oam":
+		for _, s := range d.gb.Sprites() {
+			fmt.Fprintln(d.out, s)
+		}
+
+	case "prof", "profile":
+		if d.gb.profiler == nil {
+			d.gb.StartProfile("")
+			fmt.Fprintln(d.out, "profiling started")
+			return false
+		}
+		byInclusive := len(args) == 0 || args[0] != "excl"
+		d.gb.profiler.WriteReport(d.out, byInclusive, 20)
+
+	case "cdl":
+		if d.gb.cdl == nil {
+			fmt.Fprintln(d.out, "no code/data log, start the emulator with --cdl")
+			return false
+		}
+		if len(args) > 0 && args[0] == "save" {
+			if err := d.gb.SaveCDL(); err != nil {
+				fmt.Fprintln(d.out, err)
+				return false
+			}
+			fmt.Fprintf(d.out, "saved %s\n", d.gb.cdl.filename)
+			return false
+		}
+		d.gb.cdl.log.WriteSummary(d.out)
+
+	case "q", "quit":
+		d.quit()
+
+	case "h", "help":
+		fmt.Fprint(d.out, DEBUGGER_HELP)
+
+	default:
+		fmt.Fprintf(d.out, "unknown command %q, try help\n", cmd)
+	}
+	return false
+}
+
+var DEBUGGER_HELP = `c, continue          resume execution
+s, step [n]          execute n instructions
+n, next              step over CALL and RST
+finish, out          run until the current call or interrupt returns
+bt, backtrace        print the calls, RSTs and interrupts that led to PC
+catch [reti|imbalance]
+                     toggle stopping after a return from an interrupt, or
+                     when a return does not match the call stack
+frame [n]            run until frame n, or print the current frame
+b, break label|[bank:]addr
+                     break at a label from the .sym file, or at addr,
+                     optionally only in a ROM bank
+d, delete [n]        delete breakpoint n, or all breakpoints
+bl, breakpoints      list breakpoints
+wa, watch kind range [if lhs op val] [log]
+                     watch a label or [bank:]addr[-end] for kind r, w, rw, x, chg (a
+                     write that changes the value) or =val (a write of val).
+                     lhs is a register, val or old. log prints instead of
+                     stopping
+wd, unwatch [n]      delete watchpoint n, or all watchpoints
+wl, watchpoints      list watchpoints
+r, regs              print registers
+set reg value        set a, f, b, c, d, e, h, l, af, bc, de, hl, sp, pc or ime
+x addr [n]           examine n bytes of memory
+w, write addr val... write bytes to memory
+l, list [addr]       disassemble around addr or PC
+views [prefix]       save the VRAM, tile map, OAM and palette views as PNGs
+oam                  print the decoded OAM entries
+prof, profile [excl] start profiling, or print the top functions by
+                     inclusive (or exclusive) cycles and the hotspots
+cdl [save]           print the ROM coverage of each bank, or save the
+                     code/data log
+q, quit              exit the emulator
+`
+
+func (d *Debugger) quit() {
+	d.gb.close()
+	os.Exit(0)
+}
+
+// printBacktrace prints PC, then the call site of every frame, innermost
+// first, with the way the frame above it was entered.
+func (d *Debugger) printBacktrace() {
+	calls := d.gb.calls
+	pc := d.gb.cpu.pc
+	fmt.Fprintf(d.out, "#0  %s\n", calls.location(d.gb.bankOf(pc), pc))
+	frames := calls.Frames()
+	for i := len(frames) - 1; i >= 0; i-- {
+		f := frames[i]
+		fmt.Fprintf(d.out, "#%-2d %-28s %s\n", len(frames)-i, calls.location(f.CallBank, f.CallSite), calls.describe(f))
+	}
+	if ints := calls.interrupts(); len(ints) > 0 {
+		fmt.Fprintf(d.out, "in interrupt: %s\n", strings.Join(ints, " > "))
+	}
+}
+
+func (d *Debugger) printRegisters() {
+	c := d.gb.cpu
+	fmt.Fprintf(d.out, "AF: %04X BC: %04X DE: %04X HL: %04X SP: %04X PC: %02X:%04X (%02X %02X %02X %02X)\n",
+		c.reg.getAF(c.flags.getF()), c.reg.getBC(), c.reg.getDE(), c.reg.getHL(), c.sp,
+		d.gb.bankOf(c.pc), c.pc, d.gb.mmu.readByte(c.pc), d.gb.mmu.readByte(c.pc+1), d.gb.mmu.readByte(c.pc+2), d.gb.mmu.readByte(c.pc+3))
+	fmt.Fprintf(d.out, "Z: %d N: %d H: %d C: %d IME: %t HALT: %t FRAME: %d\n",
+		c.flags.Z, c.flags.N, c.flags.H, c.flags.C, c.ime, c.halted, d.gb.frames)
+	d.printInstruction(disasm.Decode(d.gb.mmu.readByte, d.gb.labels(), c.pc))
+}
+
+func (d *Debugger) printInstruction(instr disasm.Instruction) {
+	if instr.Label != "" {
+		fmt.Fprintf(d.out, "%s:\n", instr.Label)
+	}
+	marker := "  "
+	if instr.Addr == d.gb.cpu.pc {
+		marker = "=>"
+	}
+	fmt.Fprintf(d.out, "%s %02X:%s\n", marker, d.gb.bankOf(instr.Addr), instr)
+}
+
+func (d *Debugger) dump(addr uint16, n int) {
+	for i := 0; i < n; i += 16 {
+		line := fmt.Sprintf("%04X:", addr+uint16(i))
+		for j := i; j < i+16 && j < n; j++ {
+			a := addr + uint16(j)
+			if a == d.gb.cpu.pc {
+				line += fmt.Sprintf(">%02X", d.gb.mmu.readByte(a))
+			} else {
+				line += fmt.Sprintf(" %02X", d.gb.mmu.readByte(a))
+			}
+		}
+		fmt.Fprintln(d.out, line)
+	}
+}
+
+// getRegister returns the value of a register, or -1 if there is none by
+// that name.
+func (d *Debugger) getRegister(name string) int {
+	c := d.gb.cpu
+	switch strings.ToLower(name) {
+	case "a":
+		return int(c.reg.A)
+	case "b":
+		return int(c.reg.B)
+	case "c":
+		return int(c.reg.C)
+	case "d":
+		return int(c.reg.D)
+	case "e":
+		return int(c.reg.E)
+	case "h":
+		return int(c.reg.H)
+	case "l":
+		return int(c.reg.L)
+	case "f":
+		return int(c.flags.getF())
+	case "af":
+		return int(c.reg.getAF(c.flags.getF()))
+	case "bc":
+		return int(c.reg.getBC())
+	case "de":
+		return int(c.reg.getDE())
+	case "hl":
+		return int(c.reg.getHL())
+	case "sp":
+		return int(c.sp)
+	case "pc":
+		return int(c.pc)
+	}
+	return -1
+}
+
+func (d *Debugger) setRegister(name string, val int) error {
+	c := d.gb.cpu
+	switch strings.ToLower(name) {
+	case "a":
+		c.reg.A = uint8(val)
+	case "b":
+		c.reg.B = uint8(val)
+	case "c":
+		c.reg.C = uint8(val)
+	case "d":
+		c.reg.D = uint8(val)
+	case "e":
+		c.reg.E = uint8(val)
+	case "h":
+		c.reg.H = uint8(val)
+	case "l":
+		c.reg.L = uint8(val)
+	case "f":
+		c.setF(uint8(val))
+	case "af":
+		c.reg.A = uint8(val >> 8)
+		c.setF(uint8(val))
+	case "bc":
+		c.reg.setBC(uint16(val))
+	case "de":
+		c.reg.setDE(uint16(val))
+	case "hl":
+		c.reg.setHL(uint16(val))
+	case "sp":
+		c.sp = uint16(val)
+	case "pc":
+		c.pc = uint16(val)
+	case "ime":
+		c.ime = val != 0
+	default:
+		return fmt.Errorf("unknown register %q", name)
+	}
+	return nil
+}
+
+// parseHex parses a hex number with an optional $ or 0x prefix.
+func parseHex(s string) (int, error) {
+	s = strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(s), "$"), "0x")
+	val, err := strconv.ParseUint(s, 16, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid hex number %q", s)
+	}
+	return int(val), nil
+}
@@ -0,0 +1,239 @@
+package disasm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Operand placeholders in MNEMONICS, replaced by the bytes that follow
+// the opcode:
+//
+//	d8  immediate byte         d16 immediate word
+//	a8  0xFF00+byte address    a16 word address
+//	r8  relative jump target   e8  signed byte added to SP
+var (
+	MNEMONICS = [256]string{
+		"NOP", "LD BC,d16", "LD (BC),A", "INC BC", "INC B", "DEC B", "LD B,d8", "RLCA",
+		"LD (a16),SP", "ADD HL,BC", "LD A,(BC)", "DEC BC", "INC C", "DEC C", "LD C,d8", "RRCA",
+		"STOP", "LD DE,d16", "LD (DE),A", "INC DE", "INC D", "DEC D", "LD D,d8", "RLA",
+		"JR r8", "ADD HL,DE", "LD A,(DE)", "DEC DE", "INC E", "DEC E", "LD E,d8", "RRA",
+		"JR NZ,r8", "LD HL,d16", "LD (HL+),A", "INC HL", "INC H", "DEC H", "LD H,d8", "DAA",
+		"JR Z,r8", "ADD HL,HL", "LD A,(HL+)", "DEC HL", "INC L", "DEC L", "LD L,d8", "CPL",
+		"JR NC,r8", "LD SP,d16", "LD (HL-),A", "INC SP", "INC (HL)", "DEC (HL)", "LD (HL),d8", "SCF",
+		"JR C,r8", "ADD HL,SP", "LD A,(HL-)", "DEC SP", "INC A", "DEC A", "LD A,d8", "CCF",
+		"LD B,B", "LD B,C", "LD B,D", "LD B,E", "LD B,H", "LD B,L", "LD B,(HL)", "LD B,A",
+		"LD C,B", "LD C,C", "LD C,D", "LD C,E", "LD C,H", "LD C,L", "LD C,(HL)", "LD C,A",
+		"LD D,B", "LD D,C", "LD D,D", "LD D,E", "LD D,H", "LD D,L", "LD D,(HL)", "LD D,A",
+		"LD E,B", "LD E,C", "LD E,D", "LD E,E", "LD E,H", "LD E,L", "LD E,(HL)", "LD E,A",
+		"LD H,B", "LD H,C", "LD H,D", "LD H,E", "LD H,H", "LD H,L", "LD H,(HL)", "LD H,A",
+		"LD L,B", "LD L,C", "LD L,D", "LD L,E", "LD L,H", "LD L,L", "LD L,(HL)", "LD L,A",
+		"LD (HL),B", "LD (HL),C", "LD (HL),D", "LD (HL),E", "LD (HL),H", "LD (HL),L", "HALT", "LD (HL),A",
+		"LD A,B", "LD A,C", "LD A,D", "LD A,E", "LD A,H", "LD A,L", "LD A,(HL)", "LD A,A",
+		"ADD A,B", "ADD A,C", "ADD A,D", "ADD A,E", "ADD A,H", "ADD A,L", "ADD A,(HL)", "ADD A,A",
+		"ADC A,B", "ADC A,C", "ADC A,D", "ADC A,E", "ADC A,H", "ADC A,L", "ADC A,(HL)", "ADC A,A",
+		"SUB B", "SUB C", "SUB D", "SUB E", "SUB H", "SUB L", "SUB (HL)", "SUB A",
+		"SBC A,B", "SBC A,C", "SBC A,D", "SBC A,E", "SBC A,H", "SBC A,L", "SBC A,(HL)", "SBC A,A",
+		"AND B", "AND C", "AND D", "AND E", "AND H", "AND L", "AND (HL)", "AND A",
+		"XOR B", "XOR C", "XOR D", "XOR E", "XOR H", "XOR L", "XOR (HL)", "XOR A",
+		"OR B", "OR C", "OR D", "OR E", "OR H", "OR L", "OR (HL)", "OR A",
+		"CP B", "CP C", "CP D", "CP E", "CP H", "CP L", "CP (HL)", "CP A",
+		"RET NZ", "POP BC", "JP NZ,a16", "JP a16", "CALL NZ,a16", "PUSH BC", "ADD A,d8", "RST $00",
+		"RET Z", "RET", "JP Z,a16", "PREFIX CB", "CALL Z,a16", "CALL a16", "ADC A,d8", "RST $08",
+		"RET NC", "POP DE", "JP NC,a16", "", "CALL NC,a16", "PUSH DE", "SUB d8", "RST $10",
+		"RET C", "RETI", "JP C,a16", "", "CALL C,a16", "", "SBC A,d8", "RST $18",
+		"LDH (a8),A", "POP HL", "LD (C),A", "", "", "PUSH HL", "AND d8", "RST $20",
+		"ADD SP,e8", "JP HL", "LD (a16),A", "", "", "", "XOR d8", "RST $28",
+		"LDH A,(a8)", "POP AF", "LD A,(C)", "DI", "", "PUSH AF", "OR d8", "RST $30",
+		"LD HL,SP+e8", "LD SP,HL", "LD A,(a16)", "EI", "", "", "CP d8", "RST $38",
+	}
+
+	CB_OPS    = [8]string{"RLC", "RRC", "RL", "RR", "SLA", "SRA", "SWAP", "SRL"}
+	REGISTERS = [8]string{"B", "C", "D", "E", "H", "L", "(HL)", "A"}
+)
+
+// Labels names an address, or returns "" if it has no label. A nil Labels
+// leaves every address as a number.
+type Labels func(addr uint16) string
+
+type Instruction struct {
+	Addr     uint16
+	Bytes    []uint8
+	Mnemonic string
+	Label    string
+}
+
+func (i Instruction) String() string {
+	hex := make([]string, len(i.Bytes))
+	for j, b := range i.Bytes {
+		hex[j] = fmt.Sprintf("%02X", b)
+	}
+	return fmt.Sprintf("%04X  %-9s %s", i.Addr, strings.Join(hex, " "), i.Mnemonic)
+}
+
+// IsIllegal reports whether opcode locks up the CPU on hardware.
+func IsIllegal(opcode uint8) bool {
+	return MNEMONICS[opcode] == ""
+}
+
+// Length returns the size in bytes of the instruction starting with opcode.
+func Length(opcode uint8) int {
+	if opcode == 0xCB {
+		return 2
+	}
+	m := MNEMONICS[opcode]
+	switch {
+	case strings.Contains(m, "d16"), strings.Contains(m, "a16"):
+		return 3
+	case strings.Contains(m, "d8"), strings.Contains(m, "a8"),
+		strings.Contains(m, "r8"), strings.Contains(m, "e8"):
+		return 2
+	case opcode == 0x10:
+		return 2
+	}
+	return 1
+}
+
+// Decode disassembles the instruction at addr, reading memory with read.
+// Jump targets and memory operands are named with labels.
+func Decode(read func(addr uint16) uint8, labels Labels, addr uint16) Instruction {
+	opcode := read(addr)
+	n := Length(opcode)
+	b := make([]uint8, n)
+	for i := range b {
+		b[i] = read(addr + uint16(i))
+	}
+	instr := Instruction{Addr: addr, Bytes: b, Mnemonic: format(addr, b, labels)}
+	if labels != nil {
+		instr.Label = labels(addr)
+	}
+	return instr
+}
+
+func format(addr uint16, b []uint8, labels Labels) string {
+	opcode := b[0]
+	if opcode == 0xCB {
+		return formatCB(b[1])
+	}
+
+	m := MNEMONICS[opcode]
+	switch {
+	case m == "":
+		return fmt.Sprintf("DB $%02X", opcode)
+	case opcode == 0x10:
+		return "STOP"
+	case strings.Contains(m, "d16"):
+		return strings.Replace(m, "d16", fmt.Sprintf("$%04X", word(b)), 1)
+	case strings.Contains(m, "a16"):
+		return strings.Replace(m, "a16", address(word(b), labels), 1)
+	case strings.Contains(m, "d8"):
+		return strings.Replace(m, "d8", fmt.Sprintf("$%02X", b[1]), 1)
+	case strings.Contains(m, "a8"):
+		return strings.Replace(m, "a8", address(0xFF00|uint16(b[1]), labels), 1)
+	case strings.Contains(m, "r8"):
+		target := uint16(int(addr) + 2 + int(int8(b[1])))
+		return strings.Replace(m, "r8", address(target, labels), 1)
+	case strings.Contains(m, "SP+e8"):
+		return strings.Replace(m, "+e8", signed(int8(b[1])), 1)
+	case strings.Contains(m, "e8"):
+		return strings.Replace(m, "e8", fmt.Sprintf("%d", int8(b[1])), 1)
+	}
+	return m
+}
+
+func formatCB(opcode uint8) string {
+	reg := REGISTERS[opcode&7]
+	bit := (opcode >> 3) & 7
+	switch opcode >> 6 {
+	case 0:
+		return fmt.Sprintf("%s %s", CB_OPS[bit], reg)
+	case 1:
+		return fmt.Sprintf("BIT %d,%s", bit, reg)
+	case 2:
+		return fmt.Sprintf("RES %d,%s", bit, reg)
+	}
+	return fmt.Sprintf("SET %d,%s", bit, reg)
+}
+
+func address(addr uint16, labels Labels) string {
+	if labels != nil {
+		if label := labels(addr); label != "" {
+			return label
+		}
+	}
+	return fmt.Sprintf("$%04X", addr)
+}
+
+func word(b []uint8) uint16 {
+	return (uint16(b[2]) << 8) | uint16(b[1])
+}
+
+func signed(e int8) string {
+	if e < 0 {
+		return fmt.Sprintf("-$%02X", -int(e))
+	}
+	return fmt.Sprintf("+$%02X", e)
+}
+
+// DisassembleBank disassembles a whole 16KB ROM bank linearly. Bank 0 is
+// mapped at 0x0000 and every other bank at 0x4000.
+func DisassembleBank(rom []uint8, bank int, labels Labels) []Instruction {
+	start := bank * 0x4000
+	if start >= len(rom) {
+		return nil
+	}
+	end := start + 0x4000
+	if end > len(rom) {
+		end = len(rom)
+	}
+
+	base := uint16(0x4000)
+	if bank == 0 {
+		base = 0
+	}
+	read := func(addr uint16) uint8 {
+		i := start + int(addr-base)
+		if i < end {
+			return rom[i]
+		}
+		return 0
+	}
+
+	var instrs []Instruction
+	for addr := 0; addr < end-start; {
+		instr := Decode(read, labels, base+uint16(addr))
+		instrs = append(instrs, instr)
+		addr += len(instr.Bytes)
+	}
+	return instrs
+}
+
+// Around disassembles up to before instructions leading up to addr and
+// after instructions from addr on. Since the SM83 has variable length
+// instructions, it picks the furthest start point that decodes into addr.
+func Around(read func(addr uint16) uint8, labels Labels, addr uint16, before int, after int) []Instruction {
+	var instrs []Instruction
+	for back := before * 3; back > 0; back-- {
+		var chain []Instruction
+		a := addr - uint16(back)
+		for a != addr && int(addr-a) <= back {
+			instr := Decode(read, labels, a)
+			chain = append(chain, instr)
+			a += uint16(len(instr.Bytes))
+		}
+		if a == addr {
+			instrs = chain
+			break
+		}
+	}
+	if len(instrs) > before {
+		instrs = instrs[len(instrs)-before:]
+	}
+
+	a := addr
+	for i := 0; i < after; i++ {
+		instr := Decode(read, labels, a)
+		instrs = append(instrs, instr)
+		a += uint16(len(instr.Bytes))
+	}
+	return instrs
+}
@@ -0,0 +1,46 @@
+package emu
+
+import "image"
+
+// FONT is a 3x5 pixel font for the debug views. Each row is 3 bits wide,
+// with the leftmost pixel in bit 2.
+var FONT = map[rune][5]uint8{
+	'0': {7, 5, 5, 5, 7},
+	'1': {2, 6, 2, 2, 7},
+	'2': {7, 1, 7, 4, 7},
+	'3': {7, 1, 7, 1, 7},
+	'4': {5, 5, 7, 1, 1},
+	'5': {7, 4, 7, 1, 7},
+	'6': {7, 4, 7, 5, 7},
+	'7': {7, 1, 1, 1, 1},
+	'8': {7, 5, 7, 5, 7},
+	'9': {7, 5, 7, 1, 7},
+	'A': {2, 5, 7, 5, 5},
+	'B': {6, 5, 6, 5, 6},
+	'C': {3, 4, 4, 4, 3},
+	'D': {6, 5, 5, 5, 6},
+	'E': {7, 4, 6, 4, 7},
+	'F': {7, 4, 6, 4, 4},
+	'G': {3, 4, 5, 5, 3},
+	'P': {6, 5, 6, 4, 4},
+	'T': {7, 2, 2, 2, 2},
+	'X': {5, 5, 2, 5, 5},
+	'Y': {5, 5, 2, 2, 2},
+	':': {0, 2, 0, 2, 0},
+}
+
+// drawText draws s with its top left corner at x, y. Characters that are
+// not in FONT are drawn as spaces.
+func drawText(img *image.RGBA, x, y int, s string, color uint32) {
+	for _, r := range s {
+		glyph := FONT[r]
+		for row, bits := range glyph {
+			for col := 0; col < 3; col++ {
+				if bits&(4>>col) != 0 {
+					setPixel(img, x+col, y+row, color)
+				}
+			}
+		}
+		x += 4
+	}
+}
@@ -0,0 +1,43 @@
+package emu
+
+var (
+	BUTTON_A      uint8 = 0x01
+	BUTTON_B      uint8 = 0x02
+	BUTTON_SELECT uint8 = 0x04
+	BUTTON_START  uint8 = 0x08
+	BUTTON_RIGHT  uint8 = 0x10
+	BUTTON_LEFT   uint8 = 0x20
+	BUTTON_UP     uint8 = 0x40
+	BUTTON_DOWN   uint8 = 0x80
+)
+
+// VideoSink receives a finished frame of WIDTH*HEIGHT 0xRRGGBB pixels
+// at every vblank. The slice is reused by the PPU, so it must be copied
+// if it is kept.
+type VideoSink interface {
+	DrawFrame(pixels []uint32)
+	SetTitle(title string)
+	Destroy()
+}
+
+// AudioSink receives interleaved left/right 8-bit samples at
+// apu.SAMPLE_RATE once per emulated frame. The slice is reused.
+type AudioSink interface {
+	PlaySamples(samples []uint8)
+}
+
+// Input is the state of the joypad and the frontend at one poll. Buttons
+// is a mask of the BUTTON_* values that are held down. SaveSlot and
+// LoadSlot are 1-based state slots requested since the last poll, or 0.
+// Rewind is true while the rewind key is held.
+type Input struct {
+	Buttons  uint8
+	Quit     bool
+	SaveSlot int
+	LoadSlot int
+	Rewind   bool
+}
+
+type InputSource interface {
+	Poll() Input
+}
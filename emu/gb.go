@@ -1,13 +1,16 @@
 package emu
 
 import (
+	"crypto/sha1"
 	"fmt"
+	"image"
 	"io/ioutil"
 	"os"
 	"time"
 
 	"github.com/is386/GoBoy/emu/apu"
 	"github.com/is386/GoBoy/emu/cart"
+	"github.com/is386/GoBoy/emu/symbols"
 )
 
 var (
@@ -25,26 +28,49 @@ type GameBoy struct {
 	apu            *apu.APU
 	timer          *Timer
 	buttons        *Buttons
+	serial         *Serial
+	link           *Link
+	audio          AudioSink
+	input          InputSource
 	cart           *cart.Cartridge
+	rewinder       *Rewinder
+	movie          *Movie
+	debugger       *Debugger
+	tracer         *Tracer
+	gdb            *GDBServer
+	symbols        *symbols.Table
+	views          []attachedView
+	profiler       *Profiler
+	cdl            *CodeDataLogger
+	calls          *CallStack
+	history        *History
+	romHash        [20]uint8
+	bootHash       [20]uint8
 	speed          int
 	isCGB          bool
 	isDMGCart      bool
 	cyc            int
+	frames         uint64
 	running, debug bool
+	ramCleared     bool
+	rewinding      bool
 }
 
-func NewGameBoy(rom string, bootPath string, scale int, debug bool) *GameBoy {
-	gb := &GameBoy{debug: debug, running: true, speed: 1}
+func NewGameBoy(rom string, bootPath string, debug bool, video VideoSink, audio AudioSink, input InputSource) *GameBoy {
+	gb := &GameBoy{debug: debug, running: true, audio: audio, input: input}
 
 	gb.mmu = NewMMU(gb)
-	gb.screen = NewScreen(scale)
+	gb.screen = NewScreen(video)
 	gb.ppu = NewPPU(gb)
 	gb.apu = apu.NewAPU()
 	gb.timer = NewTimer(gb)
 	gb.buttons = NewButtons(gb)
+	gb.serial = NewSerial(gb)
+	gb.history = NewHistory(HISTORY_SIZE)
 
 	gb.loadBootRom(bootPath)
 	gb.loadCart(rom)
+	gb.discoverSymbols(rom)
 	if !gb.isCGB {
 		gb.isDMGCart = gb.cart.IsDMGCart()
 	}
@@ -52,6 +78,11 @@ func NewGameBoy(rom string, bootPath string, scale int, debug bool) *GameBoy {
 
 	gb.cpu = NewCPU(gb, gb.isCGB, bootPath != "")
 
+	if debug {
+		gb.debugger = NewDebugger(gb, os.Stdin, os.Stdout)
+		gb.attachCallStack()
+	}
+
 	gb.setTitle(60)
 
 	return gb
@@ -64,6 +95,7 @@ func (gb *GameBoy) loadBootRom(filename string) {
 		return
 	}
 	gb.isCGB = len(boot) > 0x100
+	gb.bootHash = sha1.Sum(boot)
 	gb.mmu.loadBootRom(boot)
 }
 
@@ -73,6 +105,7 @@ func (gb *GameBoy) loadCart(filename string) {
 		fmt.Println(err)
 		os.Exit(0)
 	}
+	gb.romHash = sha1.Sum(rom)
 	gb.cart = cart.NewCartridge(filename, rom)
 	gb.cart.Load()
 }
@@ -89,7 +122,12 @@ func (gb *GameBoy) Run() {
 		}
 
 		frames++
-		gb.update()
+		if gb.rewinding && gb.movie == nil && !gb.linked() {
+			gb.Rewind()
+		} else {
+			gb.RunFrame()
+		}
+		gb.pollInput()
 
 		elapsed := time.Since(fpsTime)
 		if elapsed > time.Second {
@@ -101,11 +139,71 @@ func (gb *GameBoy) Run() {
 		elapsed = time.Since(saveTime)
 		if elapsed > time.Second*30 {
 			saveTime = time.Now()
-			gb.cart.Save()
+			gb.saveCart()
+			if err := gb.SaveCDL(); err != nil {
+				fmt.Println(err)
+			}
 		}
 	}
 }
 
+// RunFrame runs the emulator for one frame's worth of cycles without
+// polling the InputSource.
+func (gb *GameBoy) RunFrame() {
+	if gb.movie != nil {
+		gb.movie.update()
+	}
+	for gb.cyc < CPS {
+		gb.step()
+	}
+	gb.endFrame()
+}
+
+// StepInstruction executes one instruction, or a single cycle while the
+// CPU is halted, and returns the number of cycles it took.
+func (gb *GameBoy) StepInstruction() int {
+	cyc := gb.step()
+	if gb.cyc >= CPS {
+		gb.endFrame()
+	}
+	return cyc
+}
+
+// Framebuffer returns a copy of the last frame drawn by the PPU.
+func (gb *GameBoy) Framebuffer() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, WIDTH, HEIGHT))
+	for i, color := range gb.screen.pixels {
+		img.Pix[i*4] = uint8(color >> 16)
+		img.Pix[i*4+1] = uint8(color >> 8)
+		img.Pix[i*4+2] = uint8(color)
+		img.Pix[i*4+3] = 0xFF
+	}
+	return img
+}
+
+// SetButtons sets the joypad state to a mask of BUTTON_* values, for
+// callers driving the emulator with RunFrame instead of Run.
+func (gb *GameBoy) SetButtons(mask uint8) {
+	gb.buttons.setState(mask)
+}
+
+// bankOf returns the bank mapped at addr: the ROM bank for 0x4000-0x7FFF,
+// the VRAM bank for 0x8000-0x9FFF, the cartridge RAM bank for 0xA000-0xBFFF
+// and the WRAM bank for 0xD000-0xDFFF.
+func (gb *GameBoy) bankOf(addr uint16) int {
+	switch {
+	case addr >= 0x4000 && addr < 0x8000:
+		return int(gb.cart.GetRomBank())
+	case addr >= 0x8000 && addr < 0xA000:
+		return int(gb.mmu.vramBank)
+	case addr >= 0xA000 && addr < 0xC000:
+		return int(gb.cart.GetRamBank())
+	case addr >= 0xD000 && addr < 0xE000:
+		return int(gb.mmu.wramBank)
+	}
+	return 0
+}
+
 func (gb *GameBoy) checkBoot() {
 	if !gb.mmu.bootEnabled && gb.isCGB {
 		gb.isDMGCart = gb.cart.IsDMGCart()
@@ -113,45 +211,154 @@ func (gb *GameBoy) checkBoot() {
 }
 
 func (gb *GameBoy) changeSpeed() {
-	if gb.mmu.prepareSpeed == 1 {
-		gb.mmu.prepareSpeed = 0
-		if gb.speed == 1 {
-			gb.speed = 0
-		} else {
-			gb.speed = 1
-		}
-		gb.cpu.halted = false
+	gb.mmu.prepareSpeed = 0
+	if gb.speed == 1 {
+		gb.speed = 0
+	} else {
+		gb.speed = 1
 	}
 }
 
-func (gb *GameBoy) update() {
-	for gb.cyc < CPS {
-		cyc := 1
-		if !gb.cpu.halted {
-			if gb.debug {
-				gb.cpu.print()
+// step runs one instruction, or one M-cycle while the CPU is halted,
+// stopped, switching speed or locked up, followed by any interrupt
+// dispatch. It returns the clocks that passed.
+func (gb *GameBoy) step() int {
+	start := gb.cyc
+	cyc := 0
+	// The debugger and GDB can break in even if the CPU is not executing.
+	if gb.debugger != nil {
+		gb.debugger.check()
+	}
+	if gb.gdb != nil {
+		gb.gdb.check()
+	}
+	if gb.cpu.locked {
+		cyc = gb.tick()
+	} else if gb.cpu.parked() {
+		if gb.cpu.stopped {
+			// The clock is stopped until a button is pressed, so nothing
+			// but the frame timing moves on.
+			gb.cyc += 4
+			cyc = 4
+			if gb.buttons.isLineLow() {
+				gb.cpu.stopped = false
 			}
-			cyc = gb.cpu.execute(gb.speed + 1)
+		} else if gb.cpu.stall > 0 {
+			gb.cpu.stall--
+			cyc = gb.tick()
+		} else {
+			cyc = gb.tick()
+		}
+		if gb.profiler != nil {
+			gb.profiler.haltCycles(cyc)
+		}
+	} else {
+		if gb.tracer != nil {
+			gb.tracer.trace()
+		}
+		if gb.profiler != nil {
+			gb.profiler.before()
+		}
+		gb.history.record(gb.bankOf(gb.cpu.pc), gb.cpu.pc)
+		if gb.cdl != nil {
+			gb.cdl.opcode = true
 		}
-		gb.cyc += cyc
-		gb.ppu.update(cyc)
-		gb.timer.update(cyc)
-		gb.apu.Update(cyc)
-		gb.cpu.checkIME()
+		cyc = gb.cpu.execute()
+		if gb.profiler != nil {
+			gb.profiler.after(cyc)
+		}
+	}
+	gb.cpu.checkIME()
+	if gb.profiler != nil {
+		gb.profiler.sync()
 	}
+	if gb.link != nil {
+		gb.link.update()
+	}
+	return gb.cyc - start
+}
+
+// tick runs the PPU, timer and APU for one M-cycle of the CPU and returns
+// its length in clocks, which halves in double speed mode. The timer counts
+// CPU clocks, so it runs twice as fast in double speed mode.
+func (gb *GameBoy) tick() int {
+	cyc := 4 >> gb.speed
+	gb.cyc += cyc
+	gb.ppu.update(cyc)
+	gb.timer.update(4)
+	gb.apu.Update(cyc)
+	return cyc
+}
+
+func (gb *GameBoy) endFrame() {
 	gb.checkBoot()
-	gb.buttons.update()
+	gb.audio.PlaySamples(gb.apu.Flush())
 	gb.cyc -= CPS
+	gb.frames++
+	gb.updateViews()
+	if gb.rewinder != nil {
+		gb.rewinder.capture()
+	}
+}
+
+func (gb *GameBoy) pollInput() {
+	in := gb.input.Poll()
+	if in.Quit {
+		gb.close()
+		return
+	}
+	if gb.movie == nil || !gb.movie.playing {
+		gb.buttons.setState(in.Buttons)
+	}
+	gb.rewinding = in.Rewind
+
+	// Loading a state would desync the movie from its joypad.
+	if gb.movie != nil {
+		return
+	}
+	if in.SaveSlot > 0 && in.SaveSlot <= STATE_SLOTS {
+		if err := gb.SaveSlot(in.SaveSlot); err != nil {
+			fmt.Println(err)
+		} else {
+			fmt.Printf("Saved state to slot %d\n", in.SaveSlot)
+		}
+	}
+	if in.LoadSlot > 0 && in.LoadSlot <= STATE_SLOTS {
+		if err := gb.LoadSlot(in.LoadSlot); err != nil {
+			fmt.Println(err)
+		} else {
+			fmt.Printf("Loaded state from slot %d\n", in.LoadSlot)
+		}
+	}
+}
+
+// saveCart writes the battery save, unless a power-on movie has replaced
+// the cartridge RAM.
+func (gb *GameBoy) saveCart() {
+	if !gb.ramCleared {
+		gb.cart.Save()
+	}
 }
 
 func (gb *GameBoy) close() {
-	gb.cart.Save()
+	gb.saveCart()
+	gb.StopMovie()
+	gb.StopTrace()
+	if err := gb.StopProfile(); err != nil {
+		fmt.Println(err)
+	}
+	if err := gb.SaveCDL(); err != nil {
+		fmt.Println(err)
+	}
+	if gb.link != nil {
+		gb.link.disconnect()
+	}
 	gb.screen.Destroy()
 	gb.running = false
 }
 
 func (gb *GameBoy) setTitle(fps int) {
-	gb.screen.win.SetTitle(fmt.Sprintf("GameFella | %s | %2v FPS", gb.cart.GetName(), fps))
+	gb.screen.SetTitle(fmt.Sprintf("GameFella | %s | %2v FPS", gb.cart.GetName(), fps))
 }
 
 func (gb *GameBoy) printSerialLink() {
@@ -0,0 +1,119 @@
+package emu
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// BENCH_BUSY copies WRAM in a loop with the LCD and the VBlank interrupt on,
+// so the CPU never halts. BENCH_IDLE halts until every VBlank, like most
+// games do between frames.
+var (
+	BENCH_BUSY = []uint8{
+		0x31, 0xFE, 0xFF, // LD SP,$FFFE
+		0x3E, 0x91, 0xE0, 0x40, // LD A,$91; LDH ($40),A
+		0x3E, 0x01, 0xE0, 0xFF, // LD A,$01; LDH ($FF),A
+		0xFB,             // EI
+		0x21, 0x00, 0xC0, // LD HL,$C000
+		0x11, 0x00, 0xD0, // LD DE,$D000
+		0x06, 0x00, // LD B,0
+		0x2A,       // LD A,(HL+)
+		0x12,       // LD (DE),A
+		0x13,       // INC DE
+		0x05,       // DEC B
+		0x20, 0xFA, // JR NZ,-6
+		0x18, 0xEF, // JR -17
+	}
+	BENCH_IDLE = []uint8{
+		0x31, 0xFE, 0xFF, // LD SP,$FFFE
+		0x3E, 0x91, 0xE0, 0x40, // LD A,$91; LDH ($40),A
+		0x3E, 0x01, 0xE0, 0xFF, // LD A,$01; LDH ($FF),A
+		0xFB,       // EI
+		0x76,       // HALT
+		0x18, 0xFD, // JR -3
+	}
+	// SPEED_SWITCH switches the CGB to double speed mode.
+	SPEED_SWITCH = []uint8{
+		0x3E, 0x01, 0xE0, 0x4D, // LD A,$01; LDH ($4D),A
+		0x10, 0x00, // STOP
+		0x18, 0xFE, // JR -2
+	}
+	BENCH_HANDLER = []uint8{
+		0xF5,       // PUSH AF
+		0xF0, 0x44, // LDH A,($44)
+		0xF1, // POP AF
+		0xD9, // RETI
+	}
+)
+
+// testROM writes a 32 KiB ROM without an MBC that runs code from 0150, for
+// the CGB if cgb is true.
+func testROM(tb testing.TB, code []uint8, cgb bool) string {
+	rom := make([]uint8, 0x8000)
+	copy(rom[0x40:], BENCH_HANDLER)
+	copy(rom[0x100:], []uint8{0x00, 0xC3, 0x50, 0x01})
+	copy(rom[0x134:], "TEST")
+	if cgb {
+		rom[0x143] = 0x80
+	}
+	copy(rom[0x150:], code)
+
+	path := filepath.Join(tb.TempDir(), "test.gb")
+	if err := ioutil.WriteFile(path, rom, 0644); err != nil {
+		tb.Fatal(err)
+	}
+	return path
+}
+
+func newTestGameBoy(tb testing.TB, code []uint8, cgb bool) *GameBoy {
+	return NewGameBoy(testROM(tb, code, cgb), "", false, NullVideo{}, NullAudio{}, NullInput{})
+}
+
+func TestSpeedSwitch(t *testing.T) {
+	gb := newTestGameBoy(t, SPEED_SWITCH, true)
+	for i := 0; gb.cpu.stall == 0; i++ {
+		if i == 10 {
+			t.Fatal("STOP did not switch speed")
+		}
+		gb.step()
+	}
+	if gb.speed != 1 {
+		t.Fatal("not in double speed mode")
+	}
+
+	// The CPU pauses for SPEED_SWITCH_CYCLES M-cycles of the new speed.
+	clocks, steps := 0, 0
+	for gb.cpu.stall > 0 {
+		clocks += gb.step()
+		steps++
+	}
+	if steps != SPEED_SWITCH_CYCLES || clocks != SPEED_SWITCH_CYCLES*2 {
+		t.Errorf("paused for %d M-cycles of %d clocks, want %d of %d", steps, clocks, SPEED_SWITCH_CYCLES, SPEED_SWITCH_CYCLES*2)
+	}
+	if gb.cpu.pc != 0x0156 {
+		t.Errorf("PC is %04X after STOP, want 0156", gb.cpu.pc)
+	}
+	if div := gb.mmu.readHRAM(DIV); div != uint8(SPEED_SWITCH_CYCLES*4>>8) {
+		t.Errorf("DIV is %02X, want %02X", div, uint8(SPEED_SWITCH_CYCLES*4>>8))
+	}
+}
+
+func benchmarkFrames(b *testing.B, code []uint8) {
+	gb := newTestGameBoy(b, code, false)
+	b.ResetTimer()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		gb.RunFrame()
+	}
+	b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "frames/s")
+}
+
+func BenchmarkRunFrameBusy(b *testing.B) {
+	benchmarkFrames(b, BENCH_BUSY)
+}
+
+func BenchmarkRunFrameIdle(b *testing.B) {
+	benchmarkFrames(b, BENCH_IDLE)
+}
@@ -0,0 +1,475 @@
+package emu
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+var (
+	GDB_DISCONNECT = "\x00disconnect"
+
+	// GDB has no SM83 target, so the register layout is described to the
+	// client with target.xml. Registers are sent as 16-bit little endian
+	// pairs in this order.
+	GDB_REGISTERS  = []string{"af", "bc", "de", "hl", "sp", "pc"}
+	GDB_TARGET_XML = `<?xml version="1.0"?>
+<!DOCTYPE target SYSTEM "gdb-target.dtd">
+<target version="1.0">
+  <feature name="org.gnu.gdb.sm83.core">
+    <reg name="af" bitsize="16" type="int" regnum="0"/>
+    <reg name="bc" bitsize="16" type="int"/>
+    <reg name="de" bitsize="16" type="int"/>
+    <reg name="hl" bitsize="16" type="int"/>
+    <reg name="sp" bitsize="16" type="data_ptr"/>
+    <reg name="pc" bitsize="16" type="code_ptr"/>
+  </feature>
+</target>
+`
+)
+
+// GDBServer implements the GDB remote serial protocol over TCP. Execution
+// stops when a client connects and is then driven by the client until it
+// detaches. Breakpoint addresses above 0xFFFF select a ROM bank, e.g.
+// 0x34000 is 4000 in bank 3.
+type GDBServer struct {
+	gb          *GameBoy
+	listener    net.Listener
+	conn        net.Conn
+	packets     chan string
+	breakpoints []Breakpoint
+	interrupt   int32
+	connected   bool
+	running     bool
+	stepping    bool
+	illegal     bool
+	noAck       int32
+}
+
+// ServeGDB listens for a GDB client on addr, e.g. "localhost:2345".
+func (gb *GameBoy) ServeGDB(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	g := &GDBServer{gb: gb, listener: l, packets: make(chan string, 16)}
+	gb.gdb = g
+	gb.attachCallStack()
+	fmt.Printf("GDB server listening on %s\n", l.Addr())
+	go g.accept()
+	return nil
+}
+
+// accept serves one client at a time.
+func (g *GDBServer) accept() {
+	for {
+		conn, err := g.listener.Accept()
+		if err != nil {
+			return
+		}
+		g.conn = conn
+		atomic.StoreInt32(&g.noAck, 0)
+		atomic.StoreInt32(&g.interrupt, 1)
+		g.read(bufio.NewReader(conn))
+		conn.Close()
+		g.packets <- GDB_DISCONNECT
+	}
+}
+
+// read splits the byte stream into packets until the client disconnects. A
+// 0x03 byte outside of a packet asks the emulator to stop.
+func (g *GDBServer) read(r *bufio.Reader) {
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return
+		}
+		switch b {
+		case 0x03:
+			atomic.StoreInt32(&g.interrupt, 1)
+			continue
+		case '$':
+		default:
+			continue
+		}
+
+		data, err := r.ReadString('#')
+		if err != nil {
+			return
+		}
+		data = data[:len(data)-1]
+		var sum [2]uint8
+		if _, err := r.Read(sum[:1]); err != nil {
+			return
+		}
+		if _, err := r.Read(sum[1:]); err != nil {
+			return
+		}
+
+		want, err := strconv.ParseUint(string(sum[:]), 16, 8)
+		if err != nil || uint8(want) != checksum(data) {
+			g.conn.Write([]uint8("-"))
+			continue
+		}
+		if atomic.LoadInt32(&g.noAck) == 0 {
+			g.conn.Write([]uint8("+"))
+		}
+		g.packets <- data
+	}
+}
+
+func checksum(data string) uint8 {
+	var sum uint8
+	for i := 0; i < len(data); i++ {
+		sum += data[i]
+	}
+	return sum
+}
+
+func (g *GDBServer) send(data string) {
+	fmt.Fprintf(g.conn, "$%s#%02x", data, checksum(data))
+}
+
+// check is called before every step of the CPU and serves the client while
+// execution is stopped.
+func (g *GDBServer) check() {
+	// Clients only send 0x03 while the emulator runs, so the only packet
+	// that can be waiting here is a disconnect.
+	if g.connected {
+		select {
+		case data := <-g.packets:
+			if data == GDB_DISCONNECT {
+				g.disconnect()
+			}
+		default:
+		}
+	}
+
+	pc := g.gb.cpu.pc
+	reply := ""
+	if atomic.SwapInt32(&g.interrupt, 0) == 1 {
+		reply = "S02"
+		g.connected = true
+	}
+	if g.stepping {
+		reply = "S05"
+	}
+	for _, b := range g.breakpoints {
+		if !g.gb.cpu.parked() && b.addr == pc && (b.bank < 0 || b.bank == g.gb.bankOf(pc)) {
+			reply = "T05swbreak:;"
+		}
+	}
+	if g.illegal {
+		reply = "S04"
+		g.illegal = false
+	}
+	if reply == "" || !g.connected {
+		return
+	}
+
+	// The stop that follows a new connection is reported when the client
+	// asks for it with '?'.
+	if g.running {
+		g.send(reply)
+	}
+	g.running = false
+	g.stepping = false
+
+	for data := range g.packets {
+		if data == GDB_DISCONNECT {
+			g.disconnect()
+			return
+		}
+		if g.handle(data) {
+			g.running = true
+			return
+		}
+	}
+}
+
+// lockedUp reports an illegal opcode to the client as SIGILL.
+func (g *GDBServer) lockedUp() {
+	g.illegal = g.connected
+}
+
+func (g *GDBServer) disconnect() {
+	g.breakpoints = nil
+	g.connected = false
+	g.running = false
+	g.stepping = false
+}
+
+// handle answers one packet and returns true if execution should resume.
+func (g *GDBServer) handle(data string) bool {
+	c := g.gb.cpu
+	if data == "" {
+		g.send("")
+		return false
+	}
+
+	switch {
+	case data == "?":
+		if c.locked {
+			g.send("S04")
+		} else {
+			g.send("S05")
+		}
+
+	case data == "g":
+		var regs strings.Builder
+		for i := range GDB_REGISTERS {
+			val := g.getRegister(i)
+			fmt.Fprintf(&regs, "%02x%02x", uint8(val), uint8(val>>8))
+		}
+		g.send(regs.String())
+
+	case strings.HasPrefix(data, "G"):
+		b, err := hex.DecodeString(data[1:])
+		if err != nil || len(b) < 2*len(GDB_REGISTERS) {
+			g.send("E01")
+			return false
+		}
+		for i := range GDB_REGISTERS {
+			g.setRegister(i, uint16(b[2*i])|uint16(b[2*i+1])<<8)
+		}
+		g.send("OK")
+
+	case strings.HasPrefix(data, "p"):
+		i, err := strconv.ParseUint(data[1:], 16, 8)
+		if err != nil || int(i) >= len(GDB_REGISTERS) {
+			g.send("E01")
+			return false
+		}
+		val := g.getRegister(int(i))
+		g.send(fmt.Sprintf("%02x%02x", uint8(val), uint8(val>>8)))
+
+	case strings.HasPrefix(data, "P"):
+		parts := strings.SplitN(data[1:], "=", 2)
+		i, err := strconv.ParseUint(parts[0], 16, 8)
+		if err != nil || len(parts) != 2 || int(i) >= len(GDB_REGISTERS) {
+			g.send("E01")
+			return false
+		}
+		b, err := hex.DecodeString(parts[1])
+		if err != nil || len(b) < 2 {
+			g.send("E01")
+			return false
+		}
+		g.setRegister(int(i), uint16(b[0])|uint16(b[1])<<8)
+		g.send("OK")
+
+	case strings.HasPrefix(data, "m"):
+		addr, n, err := parseAddrLen(data[1:])
+		if err != nil {
+			g.send("E01")
+			return false
+		}
+		b := make([]uint8, n)
+		for i := range b {
+			b[i] = g.gb.mmu.readByte(addr + uint16(i))
+		}
+		g.send(hex.EncodeToString(b))
+
+	case strings.HasPrefix(data, "M"):
+		parts := strings.SplitN(data[1:], ":", 2)
+		addr, n, err := parseAddrLen(parts[0])
+		if err != nil || len(parts) != 2 {
+			g.send("E01")
+			return false
+		}
+		b, err := hex.DecodeString(parts[1])
+		if err != nil || len(b) != n {
+			g.send("E01")
+			return false
+		}
+		for i, val := range b {
+			g.gb.mmu.writeByte(addr+uint16(i), val)
+		}
+		g.send("OK")
+
+	case strings.HasPrefix(data, "Z0,"), strings.HasPrefix(data, "Z1,"):
+		b, err := parseGDBBreakpoint(data[3:])
+		if err != nil {
+			g.send("E01")
+			return false
+		}
+		g.breakpoints = append(g.breakpoints, b)
+		g.send("OK")
+
+	case strings.HasPrefix(data, "z0,"), strings.HasPrefix(data, "z1,"):
+		b, err := parseGDBBreakpoint(data[3:])
+		if err != nil {
+			g.send("E01")
+			return false
+		}
+		for i := range g.breakpoints {
+			if g.breakpoints[i] == b {
+				g.breakpoints = append(g.breakpoints[:i], g.breakpoints[i+1:]...)
+				break
+			}
+		}
+		g.send("OK")
+
+	case data == "vCont?":
+		g.send("vCont;c;C;s;S")
+
+	case strings.HasPrefix(data, "vCont;"):
+		action := strings.SplitN(data[6:], ":", 2)[0]
+		action = strings.SplitN(action, ";", 2)[0]
+		if action == "" || !strings.ContainsAny(action[:1], "cCsS") {
+			g.send("E01")
+			return false
+		}
+		g.stepping = action[0] == 's' || action[0] == 'S'
+		return true
+
+	case data[0] == 'c', data[0] == 's', data[0] == 'C', data[0] == 'S':
+		// C and S carry a signal number before the address, which is
+		// ignored since there are no signals to deliver.
+		arg := data[1:]
+		if data[0] == 'C' || data[0] == 'S' {
+			arg = ""
+			if i := strings.Index(data, ";"); i >= 0 {
+				arg = data[i+1:]
+			}
+		}
+		if arg != "" {
+			addr, err := strconv.ParseUint(arg, 16, 32)
+			if err != nil {
+				g.send("E01")
+				return false
+			}
+			c.pc = uint16(addr)
+		}
+		g.stepping = data[0] == 's' || data[0] == 'S'
+		return true
+
+	case data == "D" || strings.HasPrefix(data, "D;"):
+		g.send("OK")
+		g.disconnect()
+		return true
+
+	case data == "k":
+		g.disconnect()
+		g.gb.close()
+		os.Exit(0)
+
+	case strings.HasPrefix(data, "qSupported"):
+		g.send("PacketSize=4000;qXfer:features:read+;swbreak+;QStartNoAckMode+;vContSupported+")
+
+	case data == "QStartNoAckMode":
+		// The packets that follow the reply must not be acked, so this is set
+		// before the client can send them.
+		atomic.StoreInt32(&g.noAck, 1)
+		g.send("OK")
+
+	case strings.HasPrefix(data, "qXfer:features:read:target.xml:"):
+		addr, n, err := parseAddrLen(data[len("qXfer:features:read:target.xml:"):])
+		if err != nil {
+			g.send("E01")
+			return false
+		}
+		g.send(xferChunk(GDB_TARGET_XML, int(addr), n))
+
+	case data == "qAttached":
+		g.send("1")
+
+	case data == "qC":
+		g.send("QC1")
+
+	case data == "qfThreadInfo":
+		g.send("m1")
+
+	case data == "qsThreadInfo":
+		g.send("l")
+
+	case strings.HasPrefix(data, "H"), strings.HasPrefix(data, "T"):
+		g.send("OK")
+
+	default:
+		g.send("")
+	}
+	return false
+}
+
+func (g *GDBServer) getRegister(i int) uint16 {
+	c := g.gb.cpu
+	switch GDB_REGISTERS[i] {
+	case "af":
+		return c.reg.getAF(c.flags.getF())
+	case "bc":
+		return c.reg.getBC()
+	case "de":
+		return c.reg.getDE()
+	case "hl":
+		return c.reg.getHL()
+	case "sp":
+		return c.sp
+	}
+	return c.pc
+}
+
+func (g *GDBServer) setRegister(i int, val uint16) {
+	c := g.gb.cpu
+	switch GDB_REGISTERS[i] {
+	case "af":
+		c.reg.A = uint8(val >> 8)
+		c.setF(uint8(val))
+	case "bc":
+		c.reg.setBC(val)
+	case "de":
+		c.reg.setDE(val)
+	case "hl":
+		c.reg.setHL(val)
+	case "sp":
+		c.sp = val
+	case "pc":
+		c.pc = val
+	}
+}
+
+// parseAddrLen parses "addr,length" in hex.
+func parseAddrLen(s string) (uint16, int, error) {
+	parts := strings.SplitN(s, ",", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("expected addr,length")
+	}
+	addr, err := strconv.ParseUint(parts[0], 16, 32)
+	if err != nil {
+		return 0, 0, err
+	}
+	n, err := strconv.ParseUint(parts[1], 16, 16)
+	if err != nil {
+		return 0, 0, err
+	}
+	return uint16(addr), int(n), nil
+}
+
+// parseGDBBreakpoint parses "addr,kind", where the bits of addr above 0xFFFF
+// are the ROM bank.
+func parseGDBBreakpoint(s string) (Breakpoint, error) {
+	addr, err := strconv.ParseUint(strings.SplitN(s, ",", 2)[0], 16, 32)
+	if err != nil {
+		return Breakpoint{}, err
+	}
+	b := Breakpoint{bank: -1, addr: uint16(addr)}
+	if addr > 0xFFFF {
+		b.bank = int(addr >> 16)
+	}
+	return b, nil
+}
+
+func xferChunk(doc string, off int, n int) string {
+	if off >= len(doc) {
+		return "l"
+	}
+	if off+n >= len(doc) {
+		return "l" + doc[off:]
+	}
+	return "m" + doc[off:off+n]
+}
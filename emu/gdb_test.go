@@ -0,0 +1,193 @@
+package emu
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+)
+
+// GDB_NOPS runs NOPs from 0150 to 015F, then loops at 0160.
+var GDB_NOPS = append(make([]uint8, 0x10), 0x18, 0xFE)
+
+type gdbClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+	ack  bool
+}
+
+// startGDB connects a client to a Game Boy running code over a pipe, as if
+// it had just been accepted, and runs the Game Boy until the test ends.
+func startGDB(t *testing.T, code []uint8) *gdbClient {
+	gb := newTestGameBoy(t, code, false)
+	g := &GDBServer{gb: gb, packets: make(chan string, 16)}
+	gb.gdb = g
+	server, client := net.Pipe()
+	g.conn = server
+	atomic.StoreInt32(&g.interrupt, 1)
+	go func() {
+		g.read(bufio.NewReader(server))
+		g.packets <- GDB_DISCONNECT
+	}()
+
+	quit, done := make(chan bool), make(chan bool)
+	go func() {
+		for {
+			select {
+			case <-quit:
+				close(done)
+				return
+			default:
+				gb.step()
+			}
+		}
+	}()
+	t.Cleanup(func() {
+		client.Close()
+		close(quit)
+		<-done
+	})
+	return &gdbClient{t: t, conn: client, r: bufio.NewReader(client), ack: true}
+}
+
+func (c *gdbClient) write(packet string) {
+	if _, err := io.WriteString(c.conn, packet); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *gdbClient) readByte() uint8 {
+	b, err := c.r.ReadByte()
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return b
+}
+
+// exchange sends a packet and returns the reply.
+func (c *gdbClient) exchange(data string) string {
+	c.t.Helper()
+	c.write(fmt.Sprintf("$%s#%02x", data, checksum(data)))
+	if c.ack {
+		if b := c.readByte(); b != '+' {
+			c.t.Fatalf("%s: got %q instead of an ack", data, b)
+		}
+	}
+	return c.reply()
+}
+
+func (c *gdbClient) reply() string {
+	c.t.Helper()
+	if b := c.readByte(); b != '$' {
+		c.t.Fatalf("reply starts with %q", b)
+	}
+	data, err := c.r.ReadString('#')
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	data = data[:len(data)-1]
+	sum := string([]uint8{c.readByte(), c.readByte()})
+	if want := fmt.Sprintf("%02x", checksum(data)); sum != want {
+		c.t.Errorf("reply %q has checksum %s, want %s", data, sum, want)
+	}
+	return data
+}
+
+func (c *gdbClient) expect(data, want string) {
+	c.t.Helper()
+	if got := c.exchange(data); got != want {
+		c.t.Errorf("%s: got %q, want %q", data, got, want)
+	}
+}
+
+func TestGDBChecksum(t *testing.T) {
+	c := startGDB(t, GDB_NOPS)
+	c.write("$?#00")
+	if b := c.readByte(); b != '-' {
+		t.Fatalf("got %q for a bad checksum, want -", b)
+	}
+	c.expect("?", "S05")
+
+	c.expect("QStartNoAckMode", "OK")
+	c.ack = false
+	c.expect("?", "S05")
+}
+
+func TestGDBRegisters(t *testing.T) {
+	c := startGDB(t, GDB_NOPS)
+	// After the boot ROM: AF=0180 BC=0013 DE=00D8 HL=014D SP=FFFE PC=0100.
+	c.expect("g", "80011300d8004d01feff0001")
+	c.expect("G"+"f012"+"3412"+"7856"+"bc9a"+"00d0"+"5001", "OK")
+	c.expect("g", "f01234127856bc9a00d05001")
+	c.expect("p5", "5001")
+	c.expect("P0=3012", "OK")
+	c.expect("p0", "3012")
+
+	c.expect("G1234", "E01")
+	c.expect("p9", "E01")
+}
+
+func TestGDBMemory(t *testing.T) {
+	c := startGDB(t, GDB_NOPS)
+	c.expect("Mc000,3:abcdef", "OK")
+	c.expect("mc000,3", "abcdef")
+	c.expect("m150,2", "0000")
+	c.expect("Mc000,2:ab", "E01")
+	c.expect("mc000", "E01")
+}
+
+func TestGDBBreakpoints(t *testing.T) {
+	c := startGDB(t, GDB_NOPS)
+	c.expect("Z0,155,1", "OK")
+	c.expect("c", "T05swbreak:;")
+	c.expect("p5", "5501")
+
+	// A breakpoint in another bank does not stop.
+	c.expect("Z0,2015c,1", "OK")
+	c.expect("Z0,15a,1", "OK")
+	c.expect("c", "T05swbreak:;")
+	c.expect("p5", "5a01")
+
+	c.expect("z0,15a,1", "OK")
+	c.expect("Z0,15c,1", "OK")
+	c.expect("c", "T05swbreak:;")
+	c.expect("p5", "5c01")
+}
+
+func TestGDBStep(t *testing.T) {
+	c := startGDB(t, GDB_NOPS)
+	c.expect("s", "S05")
+	c.expect("p5", "0101")
+	// JP $0150
+	c.expect("s", "S05")
+	c.expect("p5", "5001")
+	c.expect("s158", "S05")
+	c.expect("p5", "5901")
+	c.expect("Z0,15e,1", "OK")
+	c.expect("c15c", "T05swbreak:;")
+	c.expect("p5", "5e01")
+}
+
+func TestGDBVCont(t *testing.T) {
+	tests := []struct {
+		data, want string
+	}{
+		{"vCont?", "vCont;c;C;s;S"},
+		{"vCont;s", "S05"},
+		{"vCont;s:1", "S05"},
+		{"vCont;S05", "S05"},
+		{"vCont;", "E01"},
+		{"vCont;:1", "E01"},
+		{"vCont;x", "E01"},
+		{"vCont;c", "T05swbreak:;"},
+	}
+	c := startGDB(t, GDB_NOPS)
+	c.expect("Z0,158,1", "OK")
+	for _, test := range tests {
+		c.expect(test.data, test.want)
+	}
+	c.expect("p5", "5801")
+}
@@ -0,0 +1,50 @@
+package emu
+
+type NullVideo struct{}
+
+func (NullVideo) DrawFrame(pixels []uint32) {}
+
+func (NullVideo) SetTitle(title string) {}
+
+func (NullVideo) Destroy() {}
+
+type NullAudio struct{}
+
+func (NullAudio) PlaySamples(samples []uint8) {}
+
+type NullInput struct{}
+
+func (NullInput) Poll() Input {
+	return Input{}
+}
+
+// MemoryVideo keeps a copy of the last frame drawn.
+type MemoryVideo struct {
+	Pixels []uint32
+	Title  string
+	Frames int
+}
+
+func NewMemoryVideo() *MemoryVideo {
+	return &MemoryVideo{Pixels: make([]uint32, WIDTH*HEIGHT)}
+}
+
+func (v *MemoryVideo) DrawFrame(pixels []uint32) {
+	copy(v.Pixels, pixels)
+	v.Frames++
+}
+
+func (v *MemoryVideo) SetTitle(title string) {
+	v.Title = title
+}
+
+func (v *MemoryVideo) Destroy() {}
+
+// MemoryAudio accumulates every sample played.
+type MemoryAudio struct {
+	Samples []uint8
+}
+
+func (a *MemoryAudio) PlaySamples(samples []uint8) {
+	a.Samples = append(a.Samples, samples...)
+}
@@ -1,7 +1,7 @@
 package emu
 
 var (
-	CYCLES = []int{
+	CYCLES = [256]int{
 		1, 3, 2, 2, 1, 1, 2, 1, 5, 2, 2, 2, 1, 1, 2, 1,
 		0, 3, 2, 2, 1, 1, 2, 1, 3, 2, 2, 2, 1, 1, 2, 1,
 		2, 3, 2, 2, 1, 1, 2, 1, 2, 2, 2, 2, 1, 1, 2, 1,
@@ -15,12 +15,12 @@ var (
 		1, 1, 1, 1, 1, 1, 2, 1, 1, 1, 1, 1, 1, 1, 2, 1,
 		1, 1, 1, 1, 1, 1, 2, 1, 1, 1, 1, 1, 1, 1, 2, 1,
 		2, 3, 3, 4, 3, 4, 2, 4, 2, 4, 3, 0, 3, 6, 2, 4,
-		2, 3, 3, 0, 3, 4, 2, 4, 2, 4, 3, 0, 3, 0, 2, 4,
-		3, 3, 2, 0, 0, 4, 2, 4, 4, 1, 4, 0, 0, 0, 2, 4,
-		3, 3, 2, 1, 0, 4, 2, 4, 3, 2, 4, 1, 0, 0, 2, 4,
+		2, 3, 3, 1, 3, 4, 2, 4, 2, 4, 3, 1, 3, 1, 2, 4,
+		3, 3, 2, 1, 1, 4, 2, 4, 4, 1, 4, 1, 1, 1, 2, 4,
+		3, 3, 2, 1, 1, 4, 2, 4, 3, 2, 4, 1, 1, 1, 2, 4,
 	}
 
-	CB_CYCLES = []int{
+	CB_CYCLES = [256]int{
 		2, 2, 2, 2, 2, 2, 4, 2, 2, 2, 2, 2, 2, 2, 4, 2,
 		2, 2, 2, 2, 2, 2, 4, 2, 2, 2, 2, 2, 2, 2, 4, 2,
 		2, 2, 2, 2, 2, 2, 4, 2, 2, 2, 2, 2, 2, 2, 4, 2,
@@ -39,7 +39,7 @@ var (
 		2, 2, 2, 2, 2, 2, 4, 2, 2, 2, 2, 2, 2, 2, 4, 2,
 	}
 
-	INSTRUCTIONS = map[uint8]func(*CPU){
+	INSTRUCTIONS = [256]func(*CPU){
 		0x00: nop,
 		0x01: ldBC16,
 		0x02: ldBCA,
@@ -251,7 +251,7 @@ var (
 		0xD0: retnc,
 		0xD1: popDE,
 		0xD2: jpnc,
-		0xD3: nop,
+		0xD3: illegal,
 		0xD4: callnc,
 		0xD5: pushDE,
 		0xD6: sui,
@@ -259,32 +259,32 @@ var (
 		0xD8: retc,
 		0xD9: reti,
 		0xDA: jpc,
-		0xDB: nop,
+		0xDB: illegal,
 		0xDC: callc,
-		0xDD: nop,
+		0xDD: illegal,
 		0xDE: sbi,
 		0xDF: rst18,
 		0xE0: ldh16A,
 		0xE1: popHL,
 		0xE2: ldhCA,
-		0xE3: nop,
-		0xE4: nop,
+		0xE3: illegal,
+		0xE4: illegal,
 		0xE5: pushHL,
 		0xE6: ani,
 		0xE7: rst20,
 		0xE8: addSP,
 		0xE9: jpHL,
 		0xEA: ld16A,
-		0xEB: nop,
-		0xEC: nop,
-		0xED: nop,
+		0xEB: illegal,
+		0xEC: illegal,
+		0xED: illegal,
 		0xEE: xri,
 		0xEF: rst28,
 		0xF0: ldhA16,
 		0xF1: popAF,
 		0xF2: ldhAC,
 		0xF3: di,
-		0xF4: nop,
+		0xF4: illegal,
 		0xF5: pushAF,
 		0xF6: ori,
 		0xF7: rst30,
@@ -292,13 +292,13 @@ var (
 		0xF9: ldSPHL,
 		0xFA: ldA16,
 		0xFB: ei,
-		0xFC: nop,
-		0xFD: nop,
+		0xFC: illegal,
+		0xFD: illegal,
 		0xFE: cpi,
 		0xFF: rst38,
 	}
 
-	CB_INSTRUCTIONS = map[uint8]func(*CPU){
+	CB_INSTRUCTIONS = [256]func(*CPU){
 		0x00: rlcB,
 		0x01: rlcC,
 		0x02: rlcD,
@@ -0,0 +1,75 @@
+package emu
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/is386/GoBoy/emu/disasm"
+)
+
+var JUMP_MNEMONICS = []string{"JR", "JP", "CALL", "RET", "RST"}
+
+// runOpcode executes opcode at 0200 with the flags in f. It returns the
+// length of the instruction, as the bytes read from 0201 on, and the new PC.
+func runOpcode(opcode uint8, f uint8) (int, uint16) {
+	bus := &testBus{}
+	bus.mem[0x0200] = opcode
+	// Operands and a return address that no jump can mistake for the next
+	// instruction.
+	bus.mem[0x0201], bus.mem[0x0202] = 0x10, 0x40
+	bus.mem[0xD000], bus.mem[0xD001] = 0x34, 0x12
+
+	c := newBusCPU(bus)
+	c.reg.B, c.reg.C, c.reg.D, c.reg.E, c.reg.H, c.reg.L = 0x80, 0x80, 0x80, 0x80, 0x80, 0x80
+	c.setF(f)
+	c.pc, c.sp = 0x0200, 0xD000
+	c.execute()
+
+	n := 1
+	for _, bc := range bus.cycles[1:] {
+		if bc.kind == "r-m" && bc.addr == 0x0200+uint16(n) {
+			n++
+		}
+	}
+	return n, c.pc
+}
+
+// TestMnemonics checks the disassembler's table against the CPU's: illegal
+// opcodes, instruction lengths, and which instructions jump.
+func TestMnemonics(t *testing.T) {
+	illegalFunc := reflect.ValueOf(illegal).Pointer()
+	for op := 0; op < 256; op++ {
+		opcode := uint8(op)
+		mnemonic := disasm.MNEMONICS[op]
+		isIllegal := reflect.ValueOf(INSTRUCTIONS[op]).Pointer() == illegalFunc
+		if isIllegal != disasm.IsIllegal(opcode) {
+			t.Errorf("%02X %q: illegal is %t in the CPU", opcode, mnemonic, isIllegal)
+			continue
+		}
+		if isIllegal {
+			continue
+		}
+
+		jumps := false
+		for i, f := range []uint8{0x00, 0xF0} {
+			n, pc := runOpcode(opcode, f)
+			if i == 0 && n != disasm.Length(opcode) {
+				t.Errorf("%02X %q: length is %d, the CPU reads %d bytes", opcode, mnemonic, disasm.Length(opcode), n)
+			}
+			if pc != 0x0200+uint16(n) {
+				jumps = true
+			}
+		}
+
+		isJump := false
+		for _, prefix := range JUMP_MNEMONICS {
+			if strings.HasPrefix(mnemonic, prefix) {
+				isJump = true
+			}
+		}
+		if jumps != isJump {
+			t.Errorf("%02X %q: jumps is %t in the CPU", opcode, mnemonic, jumps)
+		}
+	}
+}
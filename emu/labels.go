@@ -0,0 +1,79 @@
+package emu
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/is386/GoBoy/emu/disasm"
+	"github.com/is386/GoBoy/emu/symbols"
+)
+
+// discoverSymbols loads the .sym file next to the ROM, if there is one.
+func (gb *GameBoy) discoverSymbols(rom string) {
+	syms, path, err := symbols.Discover(rom)
+	if err != nil {
+		fmt.Printf("Could not load symbols from %s: %s\n", path, err)
+		return
+	}
+	if syms != nil {
+		gb.symbols = syms
+		fmt.Printf("Loaded %d symbols from %s\n", syms.Len(), path)
+	}
+}
+
+// LoadSymbols replaces the labels with those of an RGBDS .sym file.
+func (gb *GameBoy) LoadSymbols(filename string) error {
+	syms, err := symbols.Load(filename)
+	if err != nil {
+		return err
+	}
+	gb.symbols = syms
+	return nil
+}
+
+// labels names addresses in the banks that are currently mapped.
+func (gb *GameBoy) labels() disasm.Labels {
+	if gb.symbols == nil {
+		return nil
+	}
+	return func(addr uint16) string {
+		return gb.symbols.Lookup(gb.bankOf(addr), addr)
+	}
+}
+
+// describe names addr relative to the closest label, or returns "".
+func (gb *GameBoy) describe(addr uint16) string {
+	return gb.symbols.Describe(gb.bankOf(addr), addr)
+}
+
+// parseBreakpoint parses a label or [bank:]addr.
+func (gb *GameBoy) parseBreakpoint(arg string) (Breakpoint, error) {
+	if sym, ok := gb.symbols.Find(arg); ok {
+		return Breakpoint{bank: sym.Bank, addr: sym.Addr}, nil
+	}
+
+	b := Breakpoint{bank: -1}
+	if i := strings.Index(arg, ":"); i >= 0 {
+		bank, err := parseHex(arg[:i])
+		if err != nil {
+			return b, err
+		}
+		b.bank = bank
+		arg = arg[i+1:]
+	}
+	addr, err := parseHex(arg)
+	if err != nil {
+		if gb.symbols.Len() > 0 {
+			return b, fmt.Errorf("unknown label or invalid address %q", arg)
+		}
+		return b, err
+	}
+	b.addr = uint16(addr)
+	return b, nil
+}
+
+// parseAddress parses a label or an address.
+func (gb *GameBoy) parseAddress(arg string) (uint16, error) {
+	b, err := gb.parseBreakpoint(arg)
+	return b.addr, err
+}
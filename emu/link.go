@@ -0,0 +1,304 @@
+package emu
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+)
+
+var (
+	LINK_SYNC     uint8 = 0
+	LINK_TRANSFER uint8 = 1
+	LINK_REPLY    uint8 = 2
+	// LINK_IDLE answers a transfer when nothing was shifted, because the
+	// Game Boy was not waiting for an external clock. It reads as 0xFF.
+	LINK_IDLE uint8 = 3
+
+	// Protocols spoken over the link cable: GameFella's own, or version 1.4
+	// of the BGB link protocol.
+	LINK_GAMEFELLA = 0
+	LINK_BGB       = 1
+
+	// LINK_QUANTUM is how far, in clocks, a Game Boy may run ahead of the
+	// other. A byte sent at clock T is shifted into the other Game Boy at
+	// exactly T+LINK_QUANTUM of its clock, so transfers do not depend on
+	// how fast either process runs.
+	LINK_QUANTUM = uint64(4096)
+
+	LINK_MESSAGE_SIZE = 10
+)
+
+// linkMessage is a message of the link cable. time is the sender's clock,
+// and control is SC of a transfer, with bit 2 set in double speed mode.
+type linkMessage struct {
+	kind    uint8
+	val     uint8
+	control uint8
+	time    uint64
+}
+
+// linkProtocol puts link messages on the wire.
+type linkProtocol interface {
+	// start is run once the connection is up.
+	start(conn net.Conn) error
+	read(r io.Reader) (linkMessage, error)
+	write(w io.Writer, m linkMessage) error
+}
+
+// gameFellaProtocol sends a message as the kind, the byte and the clock, big
+// endian.
+type gameFellaProtocol struct{}
+
+func (gameFellaProtocol) start(conn net.Conn) error {
+	return nil
+}
+
+func (gameFellaProtocol) read(r io.Reader) (linkMessage, error) {
+	buf := make([]uint8, LINK_MESSAGE_SIZE)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return linkMessage{}, err
+	}
+	return linkMessage{kind: buf[0], val: buf[1], time: binary.BigEndian.Uint64(buf[2:])}, nil
+}
+
+func (gameFellaProtocol) write(w io.Writer, m linkMessage) error {
+	buf := make([]uint8, LINK_MESSAGE_SIZE)
+	buf[0], buf[1] = m.kind, m.val
+	binary.BigEndian.PutUint64(buf[2:], m.time)
+	_, err := w.Write(buf)
+	return err
+}
+
+// Link is a link cable to another emulator over TCP. The two Game Boys run
+// in lockstep: each tells the other its clock every half quantum, and waits
+// when it gets a quantum ahead.
+type Link struct {
+	gb       *GameBoy
+	conn     net.Conn
+	protocol linkProtocol
+	messages chan linkMessage
+
+	// Clocks are counted from when the cable was plugged in.
+	base     uint64
+	peerTime uint64
+	sentTime uint64
+
+	// A byte from the other Game Boy, to shift in at due.
+	pending *linkMessage
+	due     uint64
+
+	closed bool
+}
+
+// HostLink waits for another emulator to join on addr and plugs the link
+// cable in. protocol is LINK_GAMEFELLA or LINK_BGB.
+func (gb *GameBoy) HostLink(addr string, protocol int) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	fmt.Printf("Waiting for a link cable on %s\n", l.Addr())
+	conn, err := l.Accept()
+	if err != nil {
+		return err
+	}
+	return gb.connectLink(conn, protocol)
+}
+
+// JoinLink connects the link cable to the emulator hosting on addr.
+// protocol is LINK_GAMEFELLA or LINK_BGB.
+func (gb *GameBoy) JoinLink(addr string, protocol int) error {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return gb.connectLink(conn, protocol)
+}
+
+// linked reports whether the link cable is connected. The other Game Boy
+// cannot go back in time, so rewinding, loading a state and movies are
+// refused while it is.
+func (gb *GameBoy) linked() bool {
+	return gb.link != nil && !gb.link.closed
+}
+
+func (gb *GameBoy) checkUnlinked(what string) error {
+	if gb.linked() {
+		return fmt.Errorf("%s is not available while the link cable is connected", what)
+	}
+	return nil
+}
+
+func (gb *GameBoy) connectLink(conn net.Conn, protocol int) error {
+	if gb.movie != nil {
+		conn.Close()
+		return errors.New("the link cable cannot be used with a movie")
+	}
+	l := &Link{gb: gb, conn: conn, protocol: gameFellaProtocol{}, messages: make(chan linkMessage, 64)}
+	if protocol == LINK_BGB {
+		l.protocol = &bgbProtocol{}
+	}
+	if err := l.protocol.start(conn); err != nil {
+		conn.Close()
+		return err
+	}
+	fmt.Printf("Link cable connected to %s\n", conn.RemoteAddr())
+	l.base = l.clock()
+	go l.read()
+	gb.link = l
+	gb.AttachLink(l)
+	return nil
+}
+
+// clock is the number of clocks since the cable was plugged in, at normal
+// speed.
+func (l *Link) clock() uint64 {
+	return l.gb.frames*uint64(CPS) + uint64(l.gb.cyc) - l.base
+}
+
+func (l *Link) read() {
+	for {
+		m, err := l.protocol.read(l.conn)
+		if err != nil {
+			close(l.messages)
+			return
+		}
+		l.messages <- m
+	}
+}
+
+func (l *Link) send(m linkMessage) {
+	if l.closed {
+		return
+	}
+	if err := l.protocol.write(l.conn, m); err != nil {
+		l.disconnect()
+	}
+	if m.kind == LINK_SYNC || m.kind == LINK_TRANSFER {
+		l.sentTime = m.time
+	}
+}
+
+// receive waits for the next message. ok is false once the other end is
+// gone.
+func (l *Link) receive() (linkMessage, bool) {
+	if l.closed {
+		return linkMessage{}, false
+	}
+	m, ok := <-l.messages
+	if !ok {
+		l.disconnect()
+	}
+	return m, ok
+}
+
+func (l *Link) disconnect() {
+	if l.closed {
+		return
+	}
+	l.closed = true
+	l.conn.Close()
+	fmt.Println("Link cable disconnected")
+}
+
+// handle takes in a message. A byte that is sent is shifted in later.
+func (l *Link) handle(m linkMessage) {
+	if m.time > l.peerTime {
+		l.peerTime = m.time
+	}
+	if m.kind == LINK_TRANSFER {
+		l.pending = &m
+		l.due = m.time + LINK_QUANTUM
+	}
+}
+
+// update runs after every instruction.
+func (l *Link) update() {
+	if l.closed {
+		return
+	}
+	l.poll()
+	now := l.clock()
+	l.shiftIn(now)
+	if now >= l.sentTime+LINK_QUANTUM/2 {
+		l.send(linkMessage{kind: LINK_SYNC, time: now})
+	}
+	if now < l.peerTime+LINK_QUANTUM {
+		return
+	}
+	// Tell the other side where this one waits, even if a transfer just
+	// did, for peers that only follow the sync messages.
+	l.send(linkMessage{kind: LINK_SYNC, time: now})
+	for !l.closed && now >= l.peerTime+LINK_QUANTUM {
+		m, ok := l.receive()
+		if !ok {
+			return
+		}
+		l.handle(m)
+		l.shiftIn(now)
+	}
+}
+
+// poll takes in the messages that have arrived, without waiting.
+func (l *Link) poll() {
+	for !l.closed {
+		select {
+		case m, ok := <-l.messages:
+			if !ok {
+				l.disconnect()
+				return
+			}
+			l.handle(m)
+		default:
+			return
+		}
+	}
+}
+
+// shiftIn clocks in the pending byte once it is due, and replies with the
+// byte shifted out. If the Game Boy is not waiting for an external clock,
+// nothing is shifted and the other end reads 0xFF.
+func (l *Link) shiftIn(now uint64) {
+	if l.pending == nil || now < l.due {
+		return
+	}
+	reply := linkMessage{kind: LINK_IDLE, val: 0xFF, time: now}
+	if out, ok := l.gb.ClockSerial(l.pending.val); ok {
+		reply.kind, reply.val = LINK_REPLY, out
+	}
+	l.pending = nil
+	l.send(reply)
+}
+
+// Transfer sends a byte clocked by this Game Boy, and waits for the reply.
+// A byte that the other end sends meanwhile finds this one on the internal
+// clock, so it is answered with 0xFF right away.
+func (l *Link) Transfer(out uint8) uint8 {
+	now := l.clock()
+	l.shiftIn(now)
+	sc := l.gb.mmu.HRAM[COMM2]
+	control := sc&0x81 | uint8(l.gb.speed<<2)
+	if l.gb.isCGB {
+		control |= sc & 0x02
+	}
+	l.send(linkMessage{kind: LINK_TRANSFER, val: out, control: control, time: now})
+	for {
+		m, ok := l.receive()
+		if !ok {
+			return 0xFF
+		}
+		l.handle(m)
+		switch m.kind {
+		case LINK_REPLY:
+			return m.val
+		case LINK_IDLE:
+			return 0xFF
+		case LINK_TRANSFER:
+			l.pending = nil
+			l.send(linkMessage{kind: LINK_IDLE, val: 0xFF, time: now})
+		}
+	}
+}
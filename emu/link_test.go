@@ -0,0 +1,152 @@
+package emu
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+// LINK_MASTER sends 00, 01, 02... with the internal clock and stores what it
+// gets back from C000. LINK_SLAVE waits for the external clock, answering
+// with 80, 81, 82..., and stores what it gets from C000.
+var (
+	LINK_MASTER = []uint8{
+		0x21, 0x00, 0xC0, // LD HL,$C000
+		0x7D,       // LD A,L
+		0xE0, 0x01, // LDH ($01),A
+		0x3E, 0x81, 0xE0, 0x02, // LD A,$81; LDH ($02),A
+		0xF0, 0x02, // LDH A,($02)
+		0xCB, 0x7F, // BIT 7,A
+		0x20, 0xFA, // JR NZ,-6
+		0xF0, 0x01, // LDH A,($01)
+		0x22,       // LD (HL+),A
+		0x06, 0x20, // LD B,$20
+		0x05,       // DEC B
+		0x20, 0xFD, // JR NZ,-3
+		0x18, 0xE9, // JR -23
+	}
+	LINK_SLAVE = []uint8{
+		0x0E, 0x80, // LD C,$80
+		0x11, 0x00, 0xC0, // LD DE,$C000
+		0x79,       // LD A,C
+		0xE0, 0x01, // LDH ($01),A
+		0x3E, 0x80, 0xE0, 0x02, // LD A,$80; LDH ($02),A
+		0xF0, 0x02, // LDH A,($02)
+		0xCB, 0x7F, // BIT 7,A
+		0x20, 0xFA, // JR NZ,-6
+		0xF0, 0x01, // LDH A,($01)
+		0x12,       // LD (DE),A
+		0x13,       // INC DE
+		0x0C,       // INC C
+		0x18, 0xEC, // JR -20
+	}
+)
+
+// linkGameBoys connects the link cables of a and b over a localhost socket.
+func linkGameBoys(t *testing.T, a, b *GameBoy, protocol int) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	errs := make(chan error)
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			err = a.connectLink(conn, protocol)
+		}
+		errs <- err
+	}()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.connectLink(conn, protocol); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// runLinked runs b until the cable is unplugged, and a for the given number
+// of frames.
+func runLinked(a, b *GameBoy, frames int) {
+	done := make(chan bool)
+	go func() {
+		for !b.link.closed {
+			b.RunFrame()
+		}
+		done <- true
+	}()
+	for i := 0; i < frames; i++ {
+		a.RunFrame()
+	}
+	a.link.disconnect()
+	<-done
+}
+
+func TestLink(t *testing.T) {
+	tests := []struct {
+		name     string
+		protocol int
+		a, b     []uint8
+		gotA     []uint8
+		gotB     []uint8
+	}{
+		{"GameFella", LINK_GAMEFELLA, LINK_MASTER, LINK_SLAVE,
+			[]uint8{0x80, 0x81, 0x82, 0x83}, []uint8{0x00, 0x01, 0x02, 0x03}},
+		{"BGB", LINK_BGB, LINK_MASTER, LINK_SLAVE,
+			[]uint8{0x80, 0x81, 0x82, 0x83}, []uint8{0x00, 0x01, 0x02, 0x03}},
+		// Without an external clock, nothing is shifted in.
+		{"both masters", LINK_GAMEFELLA, LINK_MASTER, LINK_MASTER,
+			[]uint8{0xFF, 0xFF, 0xFF, 0xFF}, []uint8{0xFF, 0xFF, 0xFF, 0xFF}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a := newTestGameBoy(t, test.a, false)
+			b := newTestGameBoy(t, test.b, false)
+			linkGameBoys(t, a, b, test.protocol)
+			runLinked(a, b, 30)
+
+			for _, gb := range []struct {
+				name string
+				gb   *GameBoy
+				want []uint8
+			}{{"A", a, test.gotA}, {"B", b, test.gotB}} {
+				got := make([]uint8, len(gb.want))
+				for i := range got {
+					got[i] = gb.gb.mmu.readByte(0xC000 + uint16(i))
+				}
+				if !bytes.Equal(got, gb.want) {
+					t.Errorf("%s received % X, want % X", gb.name, got, gb.want)
+				}
+			}
+		})
+	}
+}
+
+func TestLinkRefusesTimeTravel(t *testing.T) {
+	a := newTestGameBoy(t, LINK_SLAVE, false)
+	b := newTestGameBoy(t, LINK_SLAVE, false)
+	a.EnableRewind(1, 1)
+	a.RunFrame()
+	var snapshot bytes.Buffer
+	if err := a.SaveState(&snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	linkGameBoys(t, a, b, LINK_GAMEFELLA)
+	defer b.link.disconnect()
+	defer a.link.disconnect()
+	if a.Rewind() {
+		t.Error("rewound while linked")
+	}
+	if err := a.LoadState(bytes.NewReader(snapshot.Bytes())); err == nil {
+		t.Error("loaded a state while linked")
+	}
+	if err := a.RecordMovie(t.TempDir()+"/movie.gfm", true); err == nil {
+		t.Error("recorded a movie while linked")
+	}
+}
@@ -0,0 +1,89 @@
+package emu
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/is386/GoBoy/emu/disasm"
+)
+
+var (
+	// HISTORY_SIZE is the number of instructions kept for the lockup report.
+	HISTORY_SIZE = 16
+)
+
+// History is a ring buffer of the last instructions executed, packed as
+// bank<<16 | addr.
+type History struct {
+	addrs []uint32
+	next  int
+	count int
+}
+
+func NewHistory(size int) *History {
+	return &History{addrs: make([]uint32, size)}
+}
+
+func (h *History) record(bank int, pc uint16) {
+	h.addrs[h.next] = packAddr(bank, pc)
+	h.next++
+	if h.next == len(h.addrs) {
+		h.next = 0
+	}
+	if h.count < len(h.addrs) {
+		h.count++
+	}
+}
+
+// Addrs returns the recorded addresses, oldest first.
+func (h *History) Addrs() []uint32 {
+	addrs := make([]uint32, 0, h.count)
+	for i := h.count; i > 0; i-- {
+		addrs = append(addrs, h.addrs[(h.next-i+len(h.addrs))%len(h.addrs)])
+	}
+	return addrs
+}
+
+// illegal runs for the opcodes that have no instruction. Like hardware, the
+// CPU locks up until the next reset: it stops fetching and ignores
+// interrupts, while the rest of the machine keeps running.
+func illegal(c *CPU) {
+	c.pc--
+	c.locked = true
+	c.bus.lockup()
+}
+
+// reportLockup stops the debugger and GDB client, if any, or prints the
+// lockup report.
+func (gb *GameBoy) reportLockup() {
+	report := gb.lockupReport()
+	if gb.gdb != nil {
+		gb.gdb.lockedUp()
+	}
+	if gb.debugger != nil {
+		gb.debugger.lockup = report
+		return
+	}
+	fmt.Print(report)
+}
+
+// lockupReport names the illegal opcode and lists the instructions that led
+// to it.
+func (gb *GameBoy) lockupReport() string {
+	var b strings.Builder
+	pc := gb.cpu.pc
+	bank := gb.bankOf(pc)
+	fmt.Fprintf(&b, "CPU locked up: illegal opcode $%02X at %02X:%04X", gb.mmu.readByte(pc), bank, pc)
+	if label := gb.symbols.Describe(bank, pc); label != "" {
+		fmt.Fprintf(&b, " (%s)", label)
+	}
+	fmt.Fprintln(&b, "\nRecent instructions:")
+
+	// Operands are read from the current memory map, so they are only
+	// accurate for banks that are still mapped.
+	for _, addr := range gb.history.Addrs() {
+		instr := disasm.Decode(gb.mmu.readByte, gb.labels(), uint16(addr))
+		fmt.Fprintf(&b, "  %02X:%s\n", addr>>16, instr)
+	}
+	return b.String()
+}
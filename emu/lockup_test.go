@@ -0,0 +1,65 @@
+package emu
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestIllegalOpcodes(t *testing.T) {
+	illegalFunc := reflect.ValueOf(illegal).Pointer()
+	n := 0
+	for op := 0; op < 0x100; op++ {
+		if reflect.ValueOf(INSTRUCTIONS[op]).Pointer() != illegalFunc {
+			continue
+		}
+		n++
+		bus := &testBus{}
+		bus.mem[0x0200] = uint8(op)
+		// A pending interrupt must not wake a locked up CPU.
+		bus.mem[0xFF0F], bus.mem[0xFFFF] = 0x01, 0x01
+		c := newBusCPU(bus)
+		c.pc, c.sp, c.ime = 0x0200, 0xD000, true
+
+		for i := 0; i < 10; i++ {
+			stepCPU(c)
+		}
+		if !c.locked || bus.lockups != 1 {
+			t.Errorf("%02X: locked is %t after %d lockups", op, c.locked, bus.lockups)
+		}
+		if c.pc != 0x0200 || c.sp != 0xD000 {
+			t.Errorf("%02X: PC=%04X SP=%04X, want 0200 D000", op, c.pc, c.sp)
+		}
+	}
+	if n != 11 {
+		t.Errorf("%d illegal opcodes, want 11", n)
+	}
+}
+
+func TestLockupReport(t *testing.T) {
+	// JP $4000 runs into a $D3 in ROM bank 1.
+	code := make([]uint8, 0x4000-0x150+1)
+	copy(code, []uint8{0xC3, 0x00, 0x40})
+	code[len(code)-1] = 0xD3
+	gb := newTestGameBoy(t, code, false)
+	for i := 0; i < 10; i++ {
+		gb.step()
+	}
+	if !gb.cpu.locked || gb.cpu.pc != 0x4000 {
+		t.Fatalf("locked is %t at %04X, want true at 4000", gb.cpu.locked, gb.cpu.pc)
+	}
+
+	report := gb.lockupReport()
+	for _, want := range []string{
+		"illegal opcode $D3 at 01:4000",
+		"Recent instructions:\n",
+		"  00:0100  00        NOP\n",
+		"  00:0101  C3 50 01  JP $0150\n",
+		"  00:0150  C3 00 40  JP $4000\n",
+		"  01:4000  D3        DB $D3\n",
+	} {
+		if !strings.Contains(report, want) {
+			t.Errorf("the report has no %q:\n%s", want, report)
+		}
+	}
+}
@@ -2,9 +2,11 @@ package emu
 
 import (
 	"github.com/is386/GoBoy/emu/bits"
+	"github.com/is386/GoBoy/emu/cdl"
+	"github.com/is386/GoBoy/emu/state"
 )
 
-var (
+const (
 	JOYPAD uint8 = 0x00
 	COMM1  uint8 = 0x01
 	COMM2  uint8 = 0x02
@@ -39,8 +41,16 @@ var (
 	SVBK   uint8 = 0x70
 )
 
+// MemoryHook observes the memory accesses made by the CPU. old is the value
+// at addr before a write.
+type MemoryHook interface {
+	memoryRead(addr uint16, val uint8)
+	memoryWrite(addr uint16, old, val uint8)
+}
+
 type MMU struct {
 	gb               *GameBoy
+	hook             MemoryHook
 	bootROM          []uint8
 	VRAM             [2][0x2000]uint8
 	WRAM0            [0x1000]uint8
@@ -119,15 +129,94 @@ func (m *MMU) initHRAM() {
 	m.HRAM[0xFF] = 0x00
 }
 
+func (m *MMU) serialize(s state.Stream) {
+	s.Bytes(m.VRAM[0][:])
+	s.Bytes(m.VRAM[1][:])
+	s.Bytes(m.WRAM0[:])
+	for i := range m.WRAM {
+		s.Bytes(m.WRAM[i][:])
+	}
+	s.Bytes(m.OAM[:])
+	s.Bytes(m.HRAM[:])
+	s.Uint8(&m.vramBank)
+	s.Uint8(&m.wramBank)
+	m.bgCRAM.serialize(s)
+	m.spriteCRAM.serialize(s)
+	s.Bool(&m.bootEnabled)
+	s.Bool(&m.bootJustDisabled)
+	s.Bool(&m.hdmaActive)
+	s.Uint8(&m.prepareSpeed)
+}
+
 func (m *MMU) loadBootRom(rom []uint8) {
 	m.bootROM = rom
 	m.bootEnabled = true
 }
 
+// cpuRead and cpuWrite are the CPU's view of the bus. They run the hook if one
+// is set, so reads made by the debugger, DMA and the PPU are not reported.
+func (m *MMU) cpuRead(addr uint16) uint8 {
+	val := m.readByte(addr)
+	if m.gb.cdl != nil {
+		m.gb.cdl.mark(addr, cdl.DATA)
+	}
+	if m.hook != nil {
+		m.hook.memoryRead(addr, val)
+	}
+	return val
+}
+
+func (m *MMU) cpuWrite(addr uint16, val uint8) {
+	if m.hook == nil {
+		m.writeByte(addr, val)
+		return
+	}
+	old := m.readByte(addr)
+	m.writeByte(addr, val)
+	m.hook.memoryWrite(addr, old, val)
+}
+
+func (m *MMU) cpuFetch(addr uint16) uint8 {
+	val := m.readByte(addr)
+	if m.gb.cdl != nil {
+		m.gb.cdl.fetch(addr)
+	}
+	return val
+}
+
+func (m *MMU) tick() int {
+	return m.gb.tick()
+}
+
+func (m *MMU) interrupts() uint8 {
+	return m.HRAM[0x0F] & m.HRAM[0xFF] & 0x1F
+}
+
+func (m *MMU) acknowledge(i int) {
+	m.writeByte(0xFF0F, bits.Reset(m.readByte(0xFF0F), uint8(i)))
+}
+
+func (m *MMU) joypadLow() bool {
+	return m.gb.buttons.isLineLow()
+}
+
+func (m *MMU) stop() bool {
+	m.writeHRAM(DIV, 0)
+	if m.gb.isCGB && m.prepareSpeed == 1 {
+		m.gb.changeSpeed()
+		return true
+	}
+	return false
+}
+
+func (m *MMU) lockup() {
+	m.gb.reportLockup()
+}
+
 func (m *MMU) readByte(addr uint16) uint8 {
 	switch addr & 0xF000 {
 	case 0x0000:
-		if m.bootEnabled && (addr < 0x100 || (addr >= 0x200 && addr < 0x900)) {
+		if m.isBootROM(addr) {
 			return m.bootROM[addr]
 		} else if m.bootEnabled && m.gb.cpu.pc == 0x100 {
 			m.bootEnabled = false
@@ -175,6 +264,11 @@ func (m *MMU) readByte(addr uint16) uint8 {
 	return 0xFF
 }
 
+// isBootROM reports whether addr is mapped to the boot ROM.
+func (m *MMU) isBootROM(addr uint16) bool {
+	return m.bootEnabled && (addr < 0x100 || (addr >= 0x200 && addr < 0x900))
+}
+
 func (m *MMU) writeByte(addr uint16, val uint8) {
 	switch addr & 0xF000 {
 
@@ -226,21 +320,19 @@ func (m *MMU) writeHRAM(reg uint8, val uint8) {
 		m.gb.buttons.writeByte(0xFF00, val)
 
 	case COMM2:
-		if val == 0x81 {
-			m.gb.printSerialLink()
-		}
+		m.gb.serial.writeSC(val)
 
 	case DIV:
-		m.gb.timer.resetDivCyc()
-		m.HRAM[DIV] = 0
+		m.gb.timer.writeDiv()
+
+	case TIMA:
+		m.gb.timer.writeTima(val)
+
+	case TMA:
+		m.gb.timer.writeTma(val)
 
 	case TAC:
-		freq0 := m.gb.timer.getTimerFreq()
-		m.HRAM[TAC] = val | 0xF8
-		freq := m.gb.timer.getTimerFreq()
-		if freq0 != freq {
-			m.gb.timer.resetTimer()
-		}
+		m.gb.timer.writeTac(val)
 
 	case STAT:
 		m.HRAM[STAT] = val | 0x80
@@ -341,6 +433,9 @@ func (m *MMU) dmaTransfer(val uint8) {
 	addr := uint16(val) << 8
 	for i := uint16(0); i < 0xA0; i++ {
 		m.writeByte(0xFE00+i, m.readByte(addr+i))
+		if m.gb.cdl != nil {
+			m.gb.cdl.mark(addr+i, cdl.DATA)
+		}
 	}
 }
 
@@ -366,6 +461,9 @@ func (m *MMU) gdmaTransfer(len uint16) {
 
 	for i := uint16(0); i < len; i++ {
 		m.VRAM[m.vramBank][dst] = m.readByte(src)
+		if m.gb.cdl != nil {
+			m.gb.cdl.mark(src, cdl.DATA|cdl.GFX)
+		}
 		src++
 		dst++
 	}
@@ -396,10 +494,6 @@ func (m *MMU) writeInterrupt(i int) {
 	m.writeByte(0xFF0F, req)
 }
 
-func (m *MMU) incrDiv() {
-	m.HRAM[DIV]++
-}
-
 func (m *MMU) incrLY() {
 	m.HRAM[LY]++
 }
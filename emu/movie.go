@@ -0,0 +1,224 @@
+package emu
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+var (
+	MOVIE_MAGIC   = [4]uint8{'G', 'F', 'M', 'V'}
+	MOVIE_VERSION = uint8(1)
+
+	MOVIE_POWER_ON = uint8(0)
+	MOVIE_STATE    = uint8(1)
+)
+
+// A movie file is a header followed by one joypad mask per frame until the
+// end of the file:
+//
+//	magic [4]uint8, version uint8, ROM SHA-1 [20]uint8, CGB uint8,
+//	boot ROM SHA-1 [20]uint8, RTC seed int64, start uint8,
+//	state length uint32, state []uint8
+//
+// The boot ROM hash is all zeros when no boot ROM ran.
+type Movie struct {
+	gb      *GameBoy
+	playing bool
+	file    *os.File
+	w       *bufio.Writer
+	inputs  []uint8
+	frame   int
+	seed    int64
+	start   uint64
+}
+
+// RecordMovie starts recording the joypad to filename. The movie starts
+// from power-on, which is only possible before the first frame has run,
+// or from a snapshot of the current state if fromState is true.
+func (gb *GameBoy) RecordMovie(filename string, fromState bool) error {
+	if err := gb.checkUnlinked("recording a movie"); err != nil {
+		return err
+	}
+	if !fromState && gb.frames > 0 {
+		return errors.New("power-on movies must start before the first frame")
+	}
+	gb.StopMovie()
+
+	var snapshot bytes.Buffer
+	start := MOVIE_POWER_ON
+	if fromState {
+		start = MOVIE_STATE
+		if err := gb.SaveState(&snapshot); err != nil {
+			return err
+		}
+	} else {
+		gb.cart.ClearRAM()
+		gb.ramCleared = true
+	}
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	m := &Movie{gb: gb, file: f, w: bufio.NewWriter(f), seed: time.Now().Unix()}
+	m.w.Write(MOVIE_MAGIC[:])
+	m.w.WriteByte(MOVIE_VERSION)
+	m.w.Write(gb.romHash[:])
+	m.w.WriteByte(boolByte(gb.isCGB))
+	m.w.Write(gb.bootHash[:])
+	writeUint(m.w, uint64(m.seed), 8)
+	m.w.WriteByte(start)
+	writeUint(m.w, uint64(snapshot.Len()), 4)
+	m.w.Write(snapshot.Bytes())
+	if err := m.w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+
+	m.begin()
+	return nil
+}
+
+// PlayMovie replaces the InputSource with the joypad recorded in
+// filename until the movie ends.
+func (gb *GameBoy) PlayMovie(filename string) error {
+	if err := gb.checkUnlinked("playing a movie"); err != nil {
+		return err
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	r := bytes.NewReader(data)
+
+	var magic [4]uint8
+	if _, err := io.ReadFull(r, magic[:]); err != nil || magic != MOVIE_MAGIC {
+		return errors.New("not a GameFella movie")
+	}
+	version, err := r.ReadByte()
+	if err != nil {
+		return errors.New("movie header is truncated")
+	}
+	if version > MOVIE_VERSION {
+		return fmt.Errorf("movie version %d is newer than supported version %d", version, MOVIE_VERSION)
+	}
+
+	// The ROM hash, CGB, boot ROM hash, seed, start and state length.
+	header := make([]uint8, 20+1+20+8+1+4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return errors.New("movie header is truncated")
+	}
+	h := bytes.NewReader(header)
+	var hash [20]uint8
+	h.Read(hash[:])
+	cgb, _ := h.ReadByte()
+	var bootHash [20]uint8
+	h.Read(bootHash[:])
+	seed := int64(readUint(h, 8))
+	start, _ := h.ReadByte()
+	size := readUint(h, 4)
+	if size > uint64(r.Len()) {
+		return errors.New("movie state is truncated")
+	}
+	snapshot := make([]uint8, size)
+	io.ReadFull(r, snapshot)
+
+	if hash != gb.romHash {
+		return errors.New("movie was recorded with a different ROM")
+	}
+	if (cgb == 1) != gb.isCGB {
+		return errors.New("movie was recorded with a different GameBoy model")
+	}
+	if bootHash != gb.bootHash {
+		return errors.New("movie was recorded with a different boot ROM, or without one")
+	}
+
+	switch start {
+	case MOVIE_POWER_ON:
+		if gb.frames > 0 {
+			return errors.New("power-on movies must start before the first frame")
+		}
+		gb.cart.ClearRAM()
+		gb.ramCleared = true
+	case MOVIE_STATE:
+		if err := gb.LoadState(bytes.NewReader(snapshot)); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown movie start type %d", start)
+	}
+
+	gb.StopMovie()
+	inputs, _ := io.ReadAll(r)
+	m := &Movie{gb: gb, playing: true, inputs: inputs, seed: seed}
+	m.begin()
+	return nil
+}
+
+// StopMovie finishes recording or playing the current movie, if any.
+func (gb *GameBoy) StopMovie() {
+	if gb.movie == nil {
+		return
+	}
+	if !gb.movie.playing {
+		gb.movie.w.Flush()
+		gb.movie.file.Close()
+	}
+	gb.movie = nil
+	gb.cart.SetClock(func() int64 { return time.Now().Unix() })
+}
+
+func (m *Movie) begin() {
+	m.start = m.gb.frames
+	m.gb.cart.SetClock(m.clock)
+	m.gb.movie = m
+}
+
+// clock drives the RTC from emulated time so that playback matches the
+// recording.
+func (m *Movie) clock() int64 {
+	return m.seed + int64(m.gb.frames-m.start)/int64(FPS)
+}
+
+func (m *Movie) update() {
+	if !m.playing {
+		m.w.WriteByte(m.gb.buttons.state())
+		return
+	}
+
+	if m.frame >= len(m.inputs) {
+		fmt.Println("Movie finished")
+		m.gb.StopMovie()
+		return
+	}
+	m.gb.buttons.setState(m.inputs[m.frame])
+	m.frame++
+}
+
+func boolByte(b bool) uint8 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func writeUint(w io.ByteWriter, val uint64, n int) {
+	for i := 0; i < n; i++ {
+		w.WriteByte(uint8(val >> (8 * i)))
+	}
+}
+
+func readUint(r io.ByteReader, n int) uint64 {
+	var val uint64
+	for i := 0; i < n; i++ {
+		b, _ := r.ReadByte()
+		val |= uint64(b) << (8 * i)
+	}
+	return val
+}
@@ -0,0 +1,111 @@
+package emu
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// JOYPAD_PALETTE shows the A and B buttons in the shade of the blank
+// background, by copying the button lines into BGP.
+var JOYPAD_PALETTE = []uint8{
+	0x3E, 0x91, 0xE0, 0x40, // LD A,$91; LDH ($40),A
+	0x3E, 0x10, 0xE0, 0x00, // LD A,$10; LDH ($00),A
+	0xF0, 0x00, // LDH A,($00)
+	0xE0, 0x47, // LDH ($47),A
+	0x18, 0xF6, // JR -10
+}
+
+var MOVIE_INPUTS = []uint8{0, 0, BUTTON_A, BUTTON_A, BUTTON_A | BUTTON_B, BUTTON_B, 0, BUTTON_B, BUTTON_A, 0}
+
+// recordMovie records MOVIE_INPUTS from power-on and returns the frames.
+func recordMovie(t *testing.T, gb *GameBoy, filename string) [][]uint8 {
+	if err := gb.RecordMovie(filename, false); err != nil {
+		t.Fatal(err)
+	}
+	var frames [][]uint8
+	for _, mask := range MOVIE_INPUTS {
+		gb.SetButtons(mask)
+		gb.RunFrame()
+		frames = append(frames, gb.Framebuffer().Pix)
+	}
+	gb.StopMovie()
+	return frames
+}
+
+func TestMovieRoundTrip(t *testing.T) {
+	rom := testROM(t, JOYPAD_PALETTE, false)
+	filename := filepath.Join(t.TempDir(), "test.gfm")
+	want := recordMovie(t, NewGameBoy(rom, "", false, NullVideo{}, NullAudio{}, NullInput{}), filename)
+	if bytes.Equal(want[1], want[4]) {
+		t.Fatal("the buttons do not change the frame")
+	}
+
+	gb := NewGameBoy(rom, "", false, NullVideo{}, NullAudio{}, NullInput{})
+	if err := gb.PlayMovie(filename); err != nil {
+		t.Fatal(err)
+	}
+	for i := range want {
+		gb.RunFrame()
+		if !bytes.Equal(gb.Framebuffer().Pix, want[i]) {
+			t.Fatalf("frame %d differs from the recording", i)
+		}
+	}
+	gb.RunFrame()
+	if gb.movie != nil {
+		t.Error("the movie did not end with its inputs")
+	}
+}
+
+func TestMovieMismatch(t *testing.T) {
+	dir := t.TempDir()
+	rom := testROM(t, JOYPAD_PALETTE, false)
+	boot := filepath.Join(dir, "boot.bin")
+	if err := ioutil.WriteFile(boot, make([]uint8, 0x100), 0644); err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(dir, "test.gfm")
+	recordMovie(t, NewGameBoy(rom, "", false, NullVideo{}, NullAudio{}, NullInput{}), filename)
+	movie, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Header offsets: ROM hash at 5, CGB at 25, boot ROM hash at 26.
+	tests := []struct {
+		name   string
+		offset int
+		rom    string
+		boot   string
+		want   string
+	}{
+		{"other ROM", -1, testROM(t, JOYPAD_PALETTE[1:], false), "", "different ROM"},
+		{"ROM hash", 5, rom, "", "different ROM"},
+		{"model", 25, rom, "", "different GameBoy model"},
+		{"boot ROM hash", 26, rom, "", "different boot ROM"},
+		{"with a boot ROM", -1, rom, boot, "different boot ROM"},
+		{"truncated", 30, rom, "", "truncated"},
+	}
+	for _, test := range tests {
+		data := append([]uint8(nil), movie...)
+		if test.name == "truncated" {
+			data = data[:test.offset]
+		} else if test.offset >= 0 {
+			data[test.offset] ^= 1
+		}
+		path := filepath.Join(dir, "bad.gfm")
+		if err := ioutil.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		gb := NewGameBoy(test.rom, test.boot, false, NullVideo{}, NullAudio{}, NullInput{})
+		err := gb.PlayMovie(path)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: playing returned %v, want %q", test.name, err, test.want)
+		}
+		if gb.movie != nil {
+			t.Errorf("%s: the movie is playing", test.name)
+		}
+	}
+}
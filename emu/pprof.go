@@ -0,0 +1,159 @@
+package emu
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+)
+
+// The pprof format is a gzipped profile.proto message. Only the fields
+// needed for a cycle profile are written, with a small protobuf encoder
+// instead of the protobuf library.
+var (
+	PPROF_SAMPLE_TYPE = 1
+	PPROF_SAMPLE      = 2
+	PPROF_MAPPING     = 3
+	PPROF_LOCATION    = 4
+	PPROF_FUNCTION    = 5
+	PPROF_STRINGS     = 6
+	PPROF_PERIOD_TYPE = 11
+	PPROF_PERIOD      = 12
+)
+
+type protoBuffer struct {
+	buf []uint8
+}
+
+func (b *protoBuffer) varint(v uint64) {
+	for v >= 0x80 {
+		b.buf = append(b.buf, uint8(v)|0x80)
+		v >>= 7
+	}
+	b.buf = append(b.buf, uint8(v))
+}
+
+func (b *protoBuffer) uint64(field int, v uint64) {
+	b.varint(uint64(field) << 3)
+	b.varint(v)
+}
+
+func (b *protoBuffer) bool(field int, v bool) {
+	if v {
+		b.uint64(field, 1)
+	}
+}
+
+func (b *protoBuffer) bytes(field int, v []uint8) {
+	b.varint(uint64(field)<<3 | 2)
+	b.varint(uint64(len(v)))
+	b.buf = append(b.buf, v...)
+}
+
+func (b *protoBuffer) packed(field int, vs []uint64) {
+	var p protoBuffer
+	for _, v := range vs {
+		p.varint(v)
+	}
+	b.bytes(field, p.buf)
+}
+
+type pprofWriter struct {
+	out       protoBuffer
+	strings   map[string]uint64
+	functions map[string]uint64
+	locations map[string]uint64
+}
+
+func (w *pprofWriter) str(s string) uint64 {
+	if id, ok := w.strings[s]; ok {
+		return id
+	}
+	id := uint64(len(w.strings))
+	w.strings[s] = id
+	w.out.bytes(PPROF_STRINGS, []uint8(s))
+	return id
+}
+
+func (w *pprofWriter) valueType(field int, typ, unit string) {
+	var vt protoBuffer
+	vt.uint64(1, w.str(typ))
+	vt.uint64(2, w.str(unit))
+	w.out.bytes(field, vt.buf)
+}
+
+func (w *pprofWriter) function(name string) uint64 {
+	if id, ok := w.functions[name]; ok {
+		return id
+	}
+	id := uint64(len(w.functions) + 1)
+	w.functions[name] = id
+
+	var f protoBuffer
+	f.uint64(1, id)
+	f.uint64(2, w.str(name))
+	f.uint64(3, w.str(name))
+	w.out.bytes(PPROF_FUNCTION, f.buf)
+	return id
+}
+
+// location returns the id of addr in function name. The address is also
+// used as the line number, so that pprof can show costs per instruction.
+func (w *pprofWriter) location(addr uint32, name string) uint64 {
+	key := fmt.Sprintf("%08X %s", addr, name)
+	if id, ok := w.locations[key]; ok {
+		return id
+	}
+	id := uint64(len(w.locations) + 1)
+	w.locations[key] = id
+
+	var line protoBuffer
+	line.uint64(1, w.function(name))
+	line.uint64(2, uint64(uint16(addr)))
+
+	var loc protoBuffer
+	loc.uint64(1, id)
+	loc.uint64(2, 1)
+	loc.uint64(3, uint64(addr))
+	loc.bytes(4, line.buf)
+	w.out.bytes(PPROF_LOCATION, loc.buf)
+	return id
+}
+
+// WritePprof writes the profile in the format read by go tool pprof, with
+// one sample per call path and address.
+func (p *Profiler) WritePprof(out io.Writer) error {
+	w := &pprofWriter{strings: map[string]uint64{}, functions: map[string]uint64{}, locations: map[string]uint64{}}
+	w.str("")
+	w.valueType(PPROF_SAMPLE_TYPE, "cycles", "count")
+	w.valueType(PPROF_PERIOD_TYPE, "cycles", "count")
+	w.out.uint64(PPROF_PERIOD, 1)
+
+	var mapping protoBuffer
+	mapping.uint64(1, 1)
+	mapping.uint64(3, 0x1000000)
+	mapping.uint64(5, w.str(p.gb.cart.GetFileName()))
+	mapping.bool(7, true)
+	mapping.bool(9, true)
+	w.out.bytes(PPROF_MAPPING, mapping.buf)
+
+	p.walk(p.root, func(node *profileNode) {
+		// The call sites from the innermost call out to the root.
+		var callers []uint64
+		for n := node; n.parent != nil; n = n.parent {
+			callers = append(callers, w.location(n.callsite, p.function(n.callsite, n.parent)))
+		}
+		for addr, cyc := range node.self {
+			ids := append([]uint64{w.location(addr, p.function(addr, node))}, callers...)
+			var sample protoBuffer
+			sample.packed(1, ids)
+			sample.packed(2, []uint64{cyc})
+			w.out.bytes(PPROF_SAMPLE, sample.buf)
+		}
+	})
+
+	gz := gzip.NewWriter(out)
+	if _, err := gz.Write(w.out.buf); err != nil {
+		return err
+	}
+	return gz.Close()
+}
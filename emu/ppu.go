@@ -2,6 +2,7 @@ package emu
 
 import (
 	"github.com/is386/GoBoy/emu/bits"
+	"github.com/is386/GoBoy/emu/state"
 )
 
 var (
@@ -25,6 +26,17 @@ func NewPPU(gb *GameBoy) *PPU {
 	return p
 }
 
+func (p *PPU) serialize(s state.Stream) {
+	s.Uint8(&p.mode)
+	s.Bool(&p.intActive)
+	s.Int(&p.cyc)
+	for x := range p.bgPriority {
+		s.Bytes(p.bgPriority[x][:])
+	}
+	s.Bytes(p.tileColorIds[:])
+	s.Int(&p.winLineCount)
+}
+
 func (p *PPU) update(cyc int) {
 	p.setLCDStatus()
 
@@ -423,7 +435,7 @@ func (p *PPU) renderSprites() {
 
 		// Byte 0 contains the y-position of the sprite plus 16.
 		// The plus 16 is for the max height of the sprite
-		y := int(p.gb.cpu.readByte(spriteBaseAddr))
+		y := int(p.gb.mmu.readByte(spriteBaseAddr))
 
 		// If the scanline is below the sprite's y-position or
 		// if the scanline is above the sprite's height, then
@@ -435,15 +447,15 @@ func (p *PPU) renderSprites() {
 
 		// Byte 1 contains the x-position of the sprite plus 8.
 		// The plus 8 is for the max width of the sprite
-		x := int(p.gb.cpu.readByte(spriteBaseAddr+1)) - 8
+		x := int(p.gb.mmu.readByte(spriteBaseAddr+1)) - 8
 
 		// Byte 2 contains the index of the tile that contains
 		// what the sprite actually looks like
-		tileIdx := p.gb.cpu.readByte(spriteBaseAddr + 2)
+		tileIdx := p.gb.mmu.readByte(spriteBaseAddr + 2)
 
 		// Byte 3 contains 8 attributes, one for each bit. They
 		// determine various things about the sprite
-		attrs := p.gb.cpu.readByte(spriteBaseAddr + 3)
+		attrs := p.gb.mmu.readByte(spriteBaseAddr + 3)
 
 		// Whether or not to flip the sprite vertically/horizontally
 		yFlip := p.isSpriteFlipY(attrs)
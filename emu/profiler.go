@@ -0,0 +1,275 @@
+package emu
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// profileNode is a call path in the profile tree. Its self map holds the
+// cycles spent at each bank:addr while this path was the innermost call.
+type profileNode struct {
+	parent   *profileNode
+	entry    uint32
+	callsite uint32
+	children map[uint64]*profileNode
+	self     map[uint32]uint64
+}
+
+// Profiler accumulates the cycles spent at every bank:addr, keyed by the
+// chain of calls and interrupts that led there. Addresses are packed as
+// bank<<16 | addr.
+type Profiler struct {
+	gb     *GameBoy
+	root   *profileNode
+	total  uint64
+	halted uint64
+	prefix string
+
+	// stack holds the node of each frame of the call stack, after the root.
+	stack  []*profileNode
+	frames []CallFrame
+	gen    uint64
+
+	// Address of the instruction being executed.
+	pc   uint16
+	bank int
+}
+
+func newProfileNode(parent *profileNode, entry, callsite uint32) *profileNode {
+	return &profileNode{parent: parent, entry: entry, callsite: callsite,
+		children: map[uint64]*profileNode{}, self: map[uint32]uint64{}}
+}
+
+// StartProfile starts a new profile. If prefix is not empty, a report is
+// written to prefix.txt and a pprof profile to prefix.pb.gz on exit.
+func (gb *GameBoy) StartProfile(prefix string) {
+	gb.attachCallStack()
+	pc := gb.cpu.pc
+	p := &Profiler{gb: gb, prefix: prefix, gen: gb.calls.gen - 1, pc: pc, bank: gb.bankOf(pc)}
+	p.root = newProfileNode(nil, packAddr(gb.bankOf(pc), pc), 0)
+	p.stack = []*profileNode{p.root}
+	gb.profiler = p
+}
+
+// Profiler returns the running profiler, or nil.
+func (gb *GameBoy) Profiler() *Profiler {
+	return gb.profiler
+}
+
+// StopProfile stops profiling and writes the files requested by
+// StartProfile.
+func (gb *GameBoy) StopProfile() error {
+	p := gb.profiler
+	if p == nil {
+		return nil
+	}
+	gb.profiler = nil
+	if p.prefix == "" {
+		return nil
+	}
+
+	f, err := os.Create(p.prefix + ".txt")
+	if err != nil {
+		return err
+	}
+	p.WriteReport(f, true, 50)
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	f, err = os.Create(p.prefix + ".pb.gz")
+	if err != nil {
+		return err
+	}
+	if err := p.WritePprof(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func packAddr(bank int, addr uint16) uint32 {
+	return uint32(bank)<<16 | uint32(addr)
+}
+
+// before is called before every instruction.
+func (p *Profiler) before() {
+	p.pc = p.gb.cpu.pc
+	p.bank = p.gb.bankOf(p.pc)
+}
+
+// after adds the cycles of the instruction that before saw.
+func (p *Profiler) after(cyc int) {
+	p.add(packAddr(p.bank, p.pc), cyc)
+	p.sync()
+}
+
+// haltCycles adds cycles spent halted, stopped or switching speed to the
+// HALT or STOP instruction that parked the CPU, which is the last one that
+// ran.
+func (p *Profiler) haltCycles(cyc int) {
+	p.add(packAddr(p.bank, p.pc), cyc)
+	p.halted += uint64(cyc)
+}
+
+func (p *Profiler) add(addr uint32, cyc int) {
+	p.stack[len(p.stack)-1].self[addr] += uint64(cyc)
+	p.total += uint64(cyc)
+}
+
+// sync follows the calls, returns and interrupts of the call stack.
+func (p *Profiler) sync() {
+	calls := p.gb.calls
+	if calls.gen == p.gen {
+		return
+	}
+	p.gen = calls.gen
+
+	// Keep the frames that are still on the call stack, then add nodes for
+	// the new ones.
+	frames := calls.Frames()
+	n := 0
+	for n < len(frames) && n < len(p.frames) && frames[n] == p.frames[n] {
+		n++
+	}
+	p.stack = p.stack[:n+1]
+	for _, f := range frames[n:] {
+		top := p.stack[len(p.stack)-1]
+		callsite, entry := packAddr(f.CallBank, f.CallSite), packAddr(f.Bank, f.Target)
+		key := uint64(callsite)<<32 | uint64(entry)
+		child, ok := top.children[key]
+		if !ok {
+			child = newProfileNode(top, entry, callsite)
+			top.children[key] = child
+		}
+		p.stack = append(p.stack, child)
+	}
+	p.frames = append(p.frames[:0], frames...)
+}
+
+// function names the function that addr belongs to. With symbols it is the
+// closest global label, otherwise the entry point of the call it ran in.
+func (p *Profiler) function(addr uint32, node *profileNode) string {
+	if label := p.gb.symbols.Describe(int(addr>>16), uint16(addr)); label != "" {
+		return strings.SplitN(strings.SplitN(label, "+", 2)[0], ".", 2)[0]
+	}
+	return fmt.Sprintf("%02X:%04X", node.entry>>16, uint16(node.entry))
+}
+
+func (p *Profiler) walk(node *profileNode, visit func(node *profileNode)) {
+	visit(node)
+	for _, child := range node.children {
+		p.walk(child, visit)
+	}
+}
+
+type profileEntry struct {
+	name                 string
+	inclusive, exclusive uint64
+}
+
+// Functions returns the inclusive and exclusive cycles of every function.
+func (p *Profiler) functions() []profileEntry {
+	entries := map[string]*profileEntry{}
+	get := func(name string) *profileEntry {
+		e, ok := entries[name]
+		if !ok {
+			e = &profileEntry{name: name}
+			entries[name] = e
+		}
+		return e
+	}
+
+	p.walk(p.root, func(node *profileNode) {
+		// The root is not a call, so code outside of any call only counts
+		// towards its own function.
+		var callers []string
+		for n := node; n.parent != nil; n = n.parent {
+			callers = append(callers, p.function(n.entry, n))
+		}
+		for addr, cyc := range node.self {
+			leaf := p.function(addr, node)
+			get(leaf).exclusive += cyc
+			seen := map[string]bool{}
+			for _, name := range append(callers, leaf) {
+				if !seen[name] {
+					seen[name] = true
+					get(name).inclusive += cyc
+				}
+			}
+		}
+	})
+
+	list := make([]profileEntry, 0, len(entries))
+	for _, e := range entries {
+		list = append(list, *e)
+	}
+	return list
+}
+
+// WriteReport writes the top functions sorted by inclusive or exclusive
+// cycles, followed by the top instructions.
+func (p *Profiler) WriteReport(w io.Writer, byInclusive bool, top int) {
+	percent := func(cyc uint64) float64 {
+		if p.total == 0 {
+			return 0
+		}
+		return float64(cyc) * 100 / float64(p.total)
+	}
+
+	fmt.Fprintf(w, "Total: %d cycles, %d (%.1f%%) halted\n\n", p.total, p.halted, percent(p.halted))
+
+	funcs := p.functions()
+	sort.Slice(funcs, func(i, j int) bool {
+		if byInclusive && funcs[i].inclusive != funcs[j].inclusive {
+			return funcs[i].inclusive > funcs[j].inclusive
+		}
+		if funcs[i].exclusive != funcs[j].exclusive {
+			return funcs[i].exclusive > funcs[j].exclusive
+		}
+		return funcs[i].name < funcs[j].name
+	})
+	if byInclusive {
+		fmt.Fprintln(w, "Functions by inclusive cycles:")
+	} else {
+		fmt.Fprintln(w, "Functions by exclusive cycles:")
+	}
+	fmt.Fprintf(w, "%12s %6s %12s %6s  %s\n", "inclusive", "%", "exclusive", "%", "function")
+	for i, f := range funcs {
+		if i == top {
+			break
+		}
+		fmt.Fprintf(w, "%12d %5.1f%% %12d %5.1f%%  %s\n",
+			f.inclusive, percent(f.inclusive), f.exclusive, percent(f.exclusive), f.name)
+	}
+
+	addrs := map[uint32]uint64{}
+	p.walk(p.root, func(node *profileNode) {
+		for addr, cyc := range node.self {
+			addrs[addr] += cyc
+		}
+	})
+	hot := make([]uint32, 0, len(addrs))
+	for addr := range addrs {
+		hot = append(hot, addr)
+	}
+	sort.Slice(hot, func(i, j int) bool {
+		if addrs[hot[i]] != addrs[hot[j]] {
+			return addrs[hot[i]] > addrs[hot[j]]
+		}
+		return hot[i] < hot[j]
+	})
+
+	fmt.Fprintln(w, "\nHotspots:")
+	fmt.Fprintf(w, "%12s %6s  %-7s  %s\n", "cycles", "%", "address", "label")
+	for i, addr := range hot {
+		if i == top {
+			break
+		}
+		label := p.gb.symbols.Describe(int(addr>>16), uint16(addr))
+		fmt.Fprintf(w, "%12d %5.1f%%  %02X:%04X  %s\n", addrs[addr], percent(addrs[addr]), addr>>16, uint16(addr), label)
+	}
+}
@@ -0,0 +1,190 @@
+package emu
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/is386/GoBoy/emu/symbols"
+)
+
+var PROFILE_SYMBOLS = "00:0038 Rst38\n00:0100 Entry\n00:0150 Main\n00:0170 Sub\n00:0171 Sub.ret\n"
+
+// profileCalls profiles CALLS until the call to 0170 has returned. Entry
+// takes 20 cycles, Main 36 up to the call, Sub 32 and the RST it runs 16.
+func profileCalls(t *testing.T, syms string) *Profiler {
+	gb := newCallsGameBoy(t)
+	if syms != "" {
+		table, err := symbols.Parse(strings.NewReader(syms))
+		if err != nil {
+			t.Fatal(err)
+		}
+		gb.symbols = table
+	}
+	gb.StartProfile("")
+	stepTo(t, gb, 0x0156)
+	return gb.Profiler()
+}
+
+func TestProfilerFunctions(t *testing.T) {
+	tests := []struct {
+		syms string
+		want []profileEntry
+	}{
+		// Without symbols, functions are named after the entry point of the
+		// call they ran in.
+		{"", []profileEntry{
+			{"00:0038", 16, 16},
+			{"00:0100", 56, 56},
+			{"00:0170", 48, 32},
+		}},
+		{PROFILE_SYMBOLS, []profileEntry{
+			{"Entry", 20, 20},
+			{"Main", 36, 36},
+			{"Rst38", 16, 16},
+			{"Sub", 48, 32},
+		}},
+	}
+	for _, test := range tests {
+		p := profileCalls(t, test.syms)
+		got := p.functions()
+		sort.Slice(got, func(i, j int) bool { return got[i].name < got[j].name })
+		if len(got) != len(test.want) {
+			t.Errorf("got functions %+v, want %+v", got, test.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("got %+v, want %+v", got[i], test.want[i])
+			}
+		}
+		if p.total != 104 {
+			t.Errorf("total is %d cycles, want 104", p.total)
+		}
+	}
+}
+
+func TestProfilerReport(t *testing.T) {
+	p := profileCalls(t, PROFILE_SYMBOLS)
+	var buf bytes.Buffer
+	p.WriteReport(&buf, true, 3)
+	want := `Total: 104 cycles, 0 (0.0%) halted
+
+Functions by inclusive cycles:
+   inclusive      %    exclusive      %  function
+          48  46.2%           32  30.8%  Sub
+          36  34.6%           36  34.6%  Main
+          20  19.2%           20  19.2%  Entry
+
+Hotspots:
+      cycles      %  address  label
+          24  23.1%  00:0153  Main+3
+          16  15.4%  00:0038  Rst38
+          16  15.4%  00:0101  Entry+1
+`
+	if buf.String() != want {
+		t.Errorf("got report:\n%s\nwant:\n%s", buf.String(), want)
+	}
+
+	buf.Reset()
+	p.WriteReport(&buf, false, 1)
+	if !strings.Contains(buf.String(), "Functions by exclusive cycles:\n   inclusive      %    exclusive      %  function\n          36  34.6%           36  34.6%  Main\n") {
+		t.Errorf("report by exclusive cycles:\n%s", buf.String())
+	}
+}
+
+// TestWritePprof checks that go tool pprof reads the profile, with each
+// sample's stack going from the instruction out through its call sites.
+func TestWritePprof(t *testing.T) {
+	gotool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go tool pprof is not available")
+	}
+	filename := filepath.Join(t.TempDir(), "test.pb.gz")
+	f, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := profileCalls(t, PROFILE_SYMBOLS).WritePprof(f); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	out, err := exec.Command(gotool, "tool", "pprof", "-raw", filename).CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+
+	// Samples are "cycles: location ids" and locations "id: addr M=1 name ...".
+	var samples []string
+	locations := map[string]string{}
+	section := ""
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) <= 1 {
+			section = line
+			continue
+		}
+		switch section {
+		case "cycles/count":
+			samples = append(samples, line)
+		case "Locations":
+			locations[fields[0]] = fields[3] + "@" + fields[1]
+		}
+	}
+	var got []string
+	for _, s := range samples {
+		fields := strings.Fields(s)
+		for i, id := range fields[1:] {
+			fields[i+1] = locations[id+":"]
+		}
+		got = append(got, strings.Join(fields, " "))
+	}
+	sort.Strings(got)
+	want := []string{
+		"12: Main@0x150",
+		"16: Entry@0x101",
+		"16: Rst38@0x38 Sub@0x170 Main@0x153",
+		"16: Sub@0x170 Main@0x153",
+		"16: Sub@0x171 Main@0x153",
+		"24: Main@0x153",
+		"4: Entry@0x100",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("go tool pprof -raw shows samples:\n%s\nwant:\n%s\n\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"), out)
+	}
+}
+
+func TestProfilerParkedCycles(t *testing.T) {
+	tests := []struct {
+		name string
+		code []uint8
+		cgb  bool
+	}{
+		{"halt", []uint8{0x00, 0x00, 0x00, 0x00, 0x76, 0x00, 0x18, 0xFE}, false},
+		{"stop", []uint8{0x00, 0x00, 0x00, 0x00, 0x10, 0x00, 0x18, 0xFE}, false},
+		{"speed switch", SPEED_SWITCH, true},
+	}
+	for _, test := range tests {
+		gb := newTestGameBoy(t, test.code, test.cgb)
+		gb.StartProfile("")
+		for i := 0; i < 3000; i++ {
+			gb.step()
+		}
+		p := gb.Profiler()
+		if p.halted == 0 {
+			t.Errorf("%s: no parked cycles", test.name)
+			continue
+		}
+		self := p.root.self
+		if self[0x0154] <= p.halted {
+			t.Errorf("%s: %d cycles at 0154, want more than the %d parked", test.name, self[0x0154], p.halted)
+		}
+		if self[0x0155] != 0 {
+			t.Errorf("%s: %d cycles at 0155", test.name, self[0x0155])
+		}
+	}
+}
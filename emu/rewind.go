@@ -0,0 +1,179 @@
+package emu
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+)
+
+var (
+	REWIND_SECONDS  = 60
+	REWIND_INTERVAL = 2
+)
+
+// Rewinder keeps the most recent snapshot uncompressed and every older one
+// as a compressed XOR delta against the snapshot taken after it, in a ring
+// that drops the oldest delta once it is full.
+type Rewinder struct {
+	gb       *GameBoy
+	interval int
+	frames   int
+	head     []uint8
+	deltas   [][]uint8
+	start    int
+	count    int
+	buf      bytes.Buffer
+	zw       *flate.Writer
+	zr       io.ReadCloser
+
+	// loaded is set once head has been loaded since it was captured, and
+	// wait counts the frames until the next step back.
+	loaded bool
+	wait   int
+}
+
+func NewRewinder(gb *GameBoy, seconds int, interval int) *Rewinder {
+	if interval < 1 {
+		interval = 1
+	}
+	size := seconds * FPS / interval
+	if size < 1 {
+		size = 1
+	}
+	zw, _ := flate.NewWriter(nil, flate.BestSpeed)
+	return &Rewinder{gb: gb, interval: interval, deltas: make([][]uint8, size), zw: zw}
+}
+
+// EnableRewind captures a snapshot every interval frames, keeping enough
+// of them to rewind by the given number of seconds. A length of 0 turns
+// rewinding off.
+func (gb *GameBoy) EnableRewind(seconds int, interval int) {
+	if seconds <= 0 {
+		gb.rewinder = nil
+		return
+	}
+	gb.rewinder = NewRewinder(gb, seconds, interval)
+}
+
+// Rewind is called once per frame instead of RunFrame while rewinding. It
+// steps the machine back to the previous snapshot every interval frames, so
+// that the game plays backwards at normal speed. It returns false if
+// rewinding is off, the link cable is connected, or there is nothing to go
+// back to.
+func (gb *GameBoy) Rewind() bool {
+	if gb.rewinder == nil || gb.linked() {
+		return false
+	}
+	return gb.rewinder.rewind()
+}
+
+func (r *Rewinder) capture() {
+	r.frames++
+	if r.frames < r.interval {
+		return
+	}
+	r.frames = 0
+
+	r.buf.Reset()
+	if err := r.gb.SaveState(&r.buf); err != nil {
+		return
+	}
+	snapshot := append([]uint8(nil), r.buf.Bytes()...)
+
+	if r.head != nil {
+		r.push(r.compress(xorBytes(r.head, snapshot)))
+	}
+	r.head = snapshot
+	r.loaded = false
+	r.wait = 0
+}
+
+func (r *Rewinder) rewind() bool {
+	if r.head == nil {
+		return false
+	}
+	if r.wait > 0 {
+		r.wait--
+		return true
+	}
+	if r.loaded && r.count == 0 {
+		return false
+	}
+	r.wait = r.interval - 1
+
+	// The first step back goes to the most recent snapshot.
+	if r.loaded {
+		delta, err := r.decompress(r.pop())
+		if err != nil {
+			r.reset()
+			return false
+		}
+		r.head = xorBytes(delta, r.head)
+	}
+	r.loaded = true
+	r.frames = 0
+
+	if err := r.gb.LoadState(bytes.NewReader(r.head)); err != nil {
+		r.reset()
+		return false
+	}
+	r.gb.screen.Update()
+	return true
+}
+
+func (r *Rewinder) reset() {
+	r.head = nil
+	r.loaded = false
+	r.wait = 0
+	r.start = 0
+	r.count = 0
+}
+
+func (r *Rewinder) push(delta []uint8) {
+	idx := (r.start + r.count) % len(r.deltas)
+	r.deltas[idx] = delta
+	if r.count < len(r.deltas) {
+		r.count++
+	} else {
+		r.start = (r.start + 1) % len(r.deltas)
+	}
+}
+
+func (r *Rewinder) pop() []uint8 {
+	r.count--
+	idx := (r.start + r.count) % len(r.deltas)
+	delta := r.deltas[idx]
+	r.deltas[idx] = nil
+	return delta
+}
+
+func (r *Rewinder) compress(data []uint8) []uint8 {
+	var out bytes.Buffer
+	r.zw.Reset(&out)
+	r.zw.Write(data)
+	r.zw.Close()
+	return out.Bytes()
+}
+
+func (r *Rewinder) decompress(data []uint8) ([]uint8, error) {
+	if r.zr == nil {
+		r.zr = flate.NewReader(bytes.NewReader(data))
+	} else {
+		r.zr.(flate.Resetter).Reset(bytes.NewReader(data), nil)
+	}
+	return io.ReadAll(r.zr)
+}
+
+// xorBytes returns a XOR b with the length of a, treating missing bytes of
+// b as zero. Applying it again with the same b gives back a.
+func xorBytes(a []uint8, b []uint8) []uint8 {
+	out := make([]uint8, len(a))
+	n := copy(out, a)
+	if len(b) < n {
+		n = len(b)
+	}
+	for i := 0; i < n; i++ {
+		out[i] ^= b[i]
+	}
+	return out
+}
@@ -0,0 +1,46 @@
+package emu
+
+import "testing"
+
+// FRAME_COUNTER counts VBlanks in C000.
+var FRAME_COUNTER = []uint8{
+	0x31, 0xFE, 0xFF, // LD SP,$FFFE
+	0x3E, 0x91, 0xE0, 0x40, // LD A,$91; LDH ($40),A
+	0x3E, 0x01, 0xE0, 0xFF, // LD A,$01; LDH ($FF),A
+	0xFB,             // EI
+	0x76,             // HALT
+	0x21, 0x00, 0xC0, // LD HL,$C000
+	0x34,       // INC (HL)
+	0x18, 0xF9, // JR -7
+}
+
+func TestRewind(t *testing.T) {
+	for _, interval := range []int{1, 2, 3} {
+		gb := newTestGameBoy(t, FRAME_COUNTER, false)
+		gb.EnableRewind(1, interval)
+
+		// The counter at each snapshot, the last one taken after frame 12.
+		var snapshots []uint8
+		for frame := 1; frame <= 12; frame++ {
+			gb.RunFrame()
+			if frame%interval == 0 {
+				snapshots = append(snapshots, gb.mmu.readByte(0xC000))
+			}
+		}
+
+		// Each snapshot is shown for interval frames, from the last one on.
+		for i := len(snapshots) - 1; i >= 0; i-- {
+			for j := 0; j < interval; j++ {
+				if !gb.Rewind() {
+					t.Fatalf("interval %d: Rewind stopped before snapshot %d", interval, i)
+				}
+				if got := gb.mmu.readByte(0xC000); got != snapshots[i] {
+					t.Fatalf("interval %d: counter is %d at snapshot %d, want %d", interval, got, i, snapshots[i])
+				}
+			}
+		}
+		if gb.Rewind() {
+			t.Errorf("interval %d: Rewind went past the first snapshot", interval)
+		}
+	}
+}
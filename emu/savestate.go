@@ -0,0 +1,111 @@
+package emu
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/is386/GoBoy/emu/state"
+)
+
+var (
+	STATE_SLOTS = 4
+)
+
+// SaveState writes a snapshot of the whole machine to w.
+func (gb *GameBoy) SaveState(w io.Writer) error {
+	s := state.NewWriter(w)
+	checksum := gb.cart.GetChecksum()
+	s.Uint16(&checksum)
+	gb.serialize(s)
+	return s.Err()
+}
+
+// LoadState restores a snapshot written by SaveState. The machine is left
+// untouched if the snapshot is invalid or belongs to another ROM.
+func (gb *GameBoy) LoadState(r io.Reader) error {
+	if err := gb.checkUnlinked("loading a state"); err != nil {
+		return err
+	}
+	s, err := state.NewReader(r)
+	if err != nil {
+		return err
+	}
+
+	var checksum uint16
+	s.Uint16(&checksum)
+	if s.Err() != nil {
+		return s.Err()
+	}
+	if checksum != gb.cart.GetChecksum() {
+		return fmt.Errorf("save state is for a different ROM (checksum %04X)", checksum)
+	}
+
+	var backup bytes.Buffer
+	if err := gb.SaveState(&backup); err != nil {
+		return err
+	}
+
+	gb.serialize(s)
+	if s.Err() != nil {
+		gb.restore(backup.Bytes())
+		return s.Err()
+	}
+	if gb.calls != nil {
+		gb.calls.reset()
+	}
+	return nil
+}
+
+func (gb *GameBoy) restore(data []uint8) {
+	s, _ := state.NewReader(bytes.NewReader(data))
+	var checksum uint16
+	s.Uint16(&checksum)
+	gb.serialize(s)
+}
+
+func (gb *GameBoy) serialize(s state.Stream) {
+	s.Int(&gb.speed)
+	s.Bool(&gb.isCGB)
+	s.Bool(&gb.isDMGCart)
+	s.Int(&gb.cyc)
+	s.Uint32s(gb.screen.pixels)
+	gb.cpu.serialize(s)
+	gb.mmu.serialize(s)
+	gb.ppu.serialize(s)
+	gb.apu.Serialize(s)
+	gb.timer.serialize(s)
+	gb.buttons.serialize(s)
+	gb.cart.Serialize(s)
+	gb.serial.serialize(s)
+}
+
+func (gb *GameBoy) slotFileName(slot int) string {
+	return fmt.Sprintf("%s.ss%d", gb.cart.GetFileName(), slot)
+}
+
+// SaveSlot writes a save state to the numbered slot next to the ROM.
+func (gb *GameBoy) SaveSlot(slot int) error {
+	f, err := os.Create(gb.slotFileName(slot))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return gb.SaveState(f)
+}
+
+// LoadSlot restores the save state in the numbered slot next to the ROM.
+func (gb *GameBoy) LoadSlot(slot int) error {
+	f, err := os.Open(gb.slotFileName(slot))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := gb.LoadState(f); err != nil {
+		return err
+	}
+	gb.screen.Update()
+	return nil
+}
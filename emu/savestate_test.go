@@ -0,0 +1,75 @@
+package emu
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/is386/GoBoy/emu/state"
+)
+
+func saveState(t *testing.T, gb *GameBoy) []uint8 {
+	var buf bytes.Buffer
+	if err := gb.SaveState(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestSaveStateRoundTrip(t *testing.T) {
+	gb := newTestGameBoy(t, FRAME_COUNTER, false)
+	for i := 0; i < 10; i++ {
+		gb.RunFrame()
+	}
+	snapshot := saveState(t, gb)
+
+	// Running on from a loaded state must give the same machine as running on
+	// from where it was saved.
+	for i := 0; i < 10; i++ {
+		gb.RunFrame()
+	}
+	want := saveState(t, gb)
+
+	if err := gb.LoadState(bytes.NewReader(snapshot)); err != nil {
+		t.Fatal(err)
+	}
+	if got := saveState(t, gb); !bytes.Equal(got, snapshot) {
+		t.Fatal("loading a state and saving it again changed it")
+	}
+	for i := 0; i < 10; i++ {
+		gb.RunFrame()
+	}
+	if got := saveState(t, gb); !bytes.Equal(got, want) {
+		t.Error("the machine ran differently after loading a state")
+	}
+}
+
+func TestLoadInvalidState(t *testing.T) {
+	gb := newTestGameBoy(t, FRAME_COUNTER, false)
+	for i := 0; i < 5; i++ {
+		gb.RunFrame()
+	}
+	snapshot := saveState(t, gb)
+	gb.RunFrame()
+	before := saveState(t, gb)
+
+	otherROM := append([]uint8(nil), snapshot...)
+	otherROM[6] ^= 0xFF
+	tests := []struct {
+		name string
+		data []uint8
+	}{
+		{"empty", nil},
+		{"magic", []uint8("GFXX\x01\x00")},
+		{"newer version", append(append([]uint8(nil), state.MAGIC[:]...), uint8(state.VERSION+1), 0)},
+		{"other ROM", otherROM},
+		{"truncated", snapshot[:len(snapshot)/2]},
+	}
+	for _, test := range tests {
+		if err := gb.LoadState(bytes.NewReader(test.data)); err == nil {
+			t.Errorf("%s: loaded an invalid state", test.name)
+		}
+		if got := saveState(t, gb); !bytes.Equal(got, before) {
+			t.Fatalf("%s: an invalid state changed the machine", test.name)
+		}
+	}
+}
@@ -1,51 +1,27 @@
 package emu
 
-import (
-	"github.com/veandco/go-sdl2/sdl"
-)
-
 type Screen struct {
-	scale int
-	win   *sdl.Window
-	sur   *sdl.Surface
+	video  VideoSink
+	pixels []uint32
 }
 
-func NewScreen(scale int) *Screen {
-	if scale < 1 {
-		scale = 1
-	}
-
-	if err := sdl.Init(sdl.INIT_VIDEO); err != nil {
-		panic(err)
-	}
-
-	win, err := sdl.CreateWindow("", sdl.WINDOWPOS_UNDEFINED, sdl.WINDOWPOS_UNDEFINED,
-		int32(WIDTH*scale), int32(HEIGHT*scale), sdl.WINDOW_ALLOW_HIGHDPI)
-	if err != nil {
-		panic(err)
-	}
-
-	sur, err := win.GetSurface()
-	if err != nil {
-		panic(err)
-	}
-
-	sur.FillRect(nil, 0xF0F0F0)
-	win.UpdateSurface()
-
-	s := Screen{scale: scale, win: win, sur: sur}
+func NewScreen(video VideoSink) *Screen {
+	s := Screen{video: video, pixels: make([]uint32, WIDTH*HEIGHT)}
 	return &s
 }
 
 func (s *Screen) Destroy() {
-	s.win.Destroy()
-	sdl.Quit()
+	s.video.Destroy()
 }
 
 func (s *Screen) Update() {
-	s.win.UpdateSurface()
+	s.video.DrawFrame(s.pixels)
+}
+
+func (s *Screen) SetTitle(title string) {
+	s.video.SetTitle(title)
 }
 
 func (s *Screen) drawPixel(x int32, y int32, color uint32) {
-	s.sur.FillRect(&sdl.Rect{X: x * int32(s.scale), Y: y * int32(s.scale), W: int32(s.scale), H: int32(s.scale)}, color)
+	s.pixels[int(y)*WIDTH+int(x)] = color
 }
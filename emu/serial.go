@@ -0,0 +1,115 @@
+package emu
+
+import (
+	"github.com/is386/GoBoy/emu/bits"
+	"github.com/is386/GoBoy/emu/state"
+)
+
+var (
+	// SERIAL_BITS is the bit of the system counter whose falling edge shifts
+	// a bit with the internal clock, at normal and CGB fast speed: 8192 Hz
+	// and 262144 Hz, twice that in double speed mode.
+	SERIAL_BITS = [2]uint{8, 3}
+)
+
+// LinkPort is a peripheral at the other end of the link cable.
+type LinkPort interface {
+	// Transfer is called when the Game Boy starts to shift out a byte with
+	// its internal clock. It returns the byte to shift in.
+	Transfer(out uint8) uint8
+}
+
+// Serial is the serial port. SB and SC are kept in HRAM.
+type Serial struct {
+	gb   *GameBoy
+	port LinkPort
+
+	// Bits left to shift with the internal clock, and the byte they come
+	// from.
+	count int
+	in    uint8
+}
+
+func NewSerial(gb *GameBoy) *Serial {
+	return &Serial{gb: gb}
+}
+
+func (s *Serial) serialize(st state.Stream) {
+	st.Int(&s.count)
+	st.Uint8(&s.in)
+}
+
+// AttachLink plugs port into the link port. With nil, nothing is plugged in
+// and the bytes sent are printed to stdout, as test ROMs report through it.
+func (gb *GameBoy) AttachLink(port LinkPort) {
+	gb.serial.port = port
+}
+
+// ClockSerial shifts a whole byte in with the clock of the other end. It
+// returns the byte shifted out, and false if the Game Boy was not waiting
+// for an external clock. It must be called from the emulation goroutine.
+func (gb *GameBoy) ClockSerial(in uint8) (uint8, bool) {
+	return gb.serial.clockIn(in)
+}
+
+func (s *Serial) writeSC(val uint8) {
+	if s.gb.isCGB {
+		s.gb.mmu.HRAM[COMM2] = val | 0x7C
+	} else {
+		s.gb.mmu.HRAM[COMM2] = val | 0x7E
+	}
+	s.count = 0
+	if !bits.Test(val, 7) || !bits.Test(val, 0) {
+		return
+	}
+
+	s.count = 8
+	out := s.gb.mmu.HRAM[COMM1]
+	if s.port != nil {
+		s.in = s.port.Transfer(out)
+	} else {
+		s.in = 0xFF
+		s.gb.printSerialLink()
+	}
+}
+
+// update runs when the system counter changes from old to val.
+func (s *Serial) update(old, val uint16) {
+	if s.count == 0 {
+		return
+	}
+	bit := SERIAL_BITS[0]
+	if s.gb.isCGB && bits.Test(s.gb.mmu.HRAM[COMM2], 1) {
+		bit = SERIAL_BITS[1]
+	}
+	if (old>>bit)&1 == 1 && (val>>bit)&1 == 0 {
+		s.shift()
+	}
+}
+
+// shift moves SB left, with the next incoming bit in bit 0.
+func (s *Serial) shift() {
+	sb := s.gb.mmu.HRAM[COMM1]<<1 | s.in>>7
+	s.gb.mmu.HRAM[COMM1] = sb
+	s.in <<= 1
+	s.count--
+	if s.count == 0 {
+		s.complete()
+	}
+}
+
+func (s *Serial) complete() {
+	s.gb.mmu.HRAM[COMM2] = bits.Reset(s.gb.mmu.HRAM[COMM2], 7)
+	s.gb.mmu.writeInterrupt(INT_SERIAL)
+}
+
+func (s *Serial) clockIn(in uint8) (uint8, bool) {
+	sc := s.gb.mmu.HRAM[COMM2]
+	if !bits.Test(sc, 7) || bits.Test(sc, 0) {
+		return 0xFF, false
+	}
+	out := s.gb.mmu.HRAM[COMM1]
+	s.gb.mmu.HRAM[COMM1] = in
+	s.complete()
+	return out, true
+}
@@ -0,0 +1,135 @@
+package emu
+
+import (
+	"testing"
+
+	"github.com/is386/GoBoy/emu/bits"
+)
+
+// newSerialGameBoy returns a Game Boy with the system counter at 0 and no
+// interrupt requested.
+func newSerialGameBoy(t *testing.T, cgb bool) *GameBoy {
+	gb := newTestGameBoy(t, []uint8{0x18, 0xFE}, cgb)
+	gb.timer.counter = 0
+	gb.mmu.HRAM[0x0F] = 0xE0
+	return gb
+}
+
+func serialRequested(gb *GameBoy) bool {
+	return gb.mmu.HRAM[0x0F]&(1<<INT_SERIAL) != 0
+}
+
+// runSerial runs the timer until the transfer completes and returns the
+// clocks it took.
+func runSerial(t *testing.T, gb *GameBoy) int {
+	clocks := 0
+	for bits.Test(gb.mmu.readByte(0xFF02), 7) {
+		if serialRequested(gb) {
+			t.Fatal("the interrupt was requested before the transfer completed")
+		}
+		if clocks > 0x10000 {
+			t.Fatal("the transfer did not complete")
+		}
+		gb.timer.update(4)
+		clocks += 4
+	}
+	return clocks
+}
+
+type testLinkPort struct {
+	out   []uint8
+	reply uint8
+}
+
+func (p *testLinkPort) Transfer(out uint8) uint8 {
+	p.out = append(p.out, out)
+	return p.reply
+}
+
+func TestSerialInternalClock(t *testing.T) {
+	tests := []struct {
+		name   string
+		cgb    bool
+		sc     uint8
+		clocks int
+		scRead uint8
+	}{
+		{"8192 Hz", false, 0x81, 4096, 0x7F},
+		{"fast bit on DMG", false, 0x83, 4096, 0x7F},
+		{"CGB 8192 Hz", true, 0x81, 4096, 0x7D},
+		{"CGB fast", true, 0x83, 128, 0x7F},
+	}
+	for _, test := range tests {
+		gb := newSerialGameBoy(t, test.cgb)
+		port := &testLinkPort{reply: 0x5A}
+		gb.AttachLink(port)
+		gb.mmu.writeByte(0xFF01, 0xC3)
+		gb.mmu.writeByte(0xFF02, test.sc)
+
+		if clocks := runSerial(t, gb); clocks != test.clocks {
+			t.Errorf("%s: the transfer took %d clocks, want %d", test.name, clocks, test.clocks)
+		}
+		if !serialRequested(gb) {
+			t.Errorf("%s: no serial interrupt after 8 bits", test.name)
+		}
+		if len(port.out) != 1 || port.out[0] != 0xC3 {
+			t.Errorf("%s: sent % X, want C3", test.name, port.out)
+		}
+		if sb := gb.mmu.readByte(0xFF01); sb != 0x5A {
+			t.Errorf("%s: SB is %02X, want 5A", test.name, sb)
+		}
+		if sc := gb.mmu.readByte(0xFF02); sc != test.scRead {
+			t.Errorf("%s: SC reads %02X, want %02X", test.name, sc, test.scRead)
+		}
+	}
+}
+
+func TestSerialShift(t *testing.T) {
+	gb := newSerialGameBoy(t, false)
+	gb.AttachLink(&testLinkPort{reply: 0x5A})
+	gb.mmu.writeByte(0xFF01, 0xC3)
+	gb.mmu.writeByte(0xFF02, 0x81)
+
+	// Each bit of SB goes out at the top, as a bit of the reply comes in at
+	// the bottom.
+	for i := 0; i < 4*512/4; i++ {
+		gb.timer.update(4)
+	}
+	if sb := gb.mmu.readByte(0xFF01); sb != 0x35 {
+		t.Errorf("SB is %02X after 4 bits, want 35", sb)
+	}
+}
+
+func TestSerialUnplugged(t *testing.T) {
+	// Without a LinkPort, the internal clock shifts in 1s.
+	gb := newSerialGameBoy(t, false)
+	gb.debug = true
+	gb.mmu.writeByte(0xFF01, 0x42)
+	gb.mmu.writeByte(0xFF02, 0x81)
+	runSerial(t, gb)
+	if sb := gb.mmu.readByte(0xFF01); sb != 0xFF || !serialRequested(gb) {
+		t.Errorf("SB is %02X, interrupt %t, want FF and an interrupt", sb, serialRequested(gb))
+	}
+
+	// With the external clock, nothing happens until the other end clocks
+	// a byte in.
+	gb = newSerialGameBoy(t, false)
+	gb.mmu.writeByte(0xFF01, 0x42)
+	gb.mmu.writeByte(0xFF02, 0x80)
+	for i := 0; i < 0x4000; i++ {
+		gb.timer.update(4)
+	}
+	if sb, sc := gb.mmu.readByte(0xFF01), gb.mmu.readByte(0xFF02); sb != 0x42 || sc != 0xFE || serialRequested(gb) {
+		t.Fatalf("SB is %02X and SC %02X, interrupt %t, want 42 FE and no interrupt", sb, sc, serialRequested(gb))
+	}
+	out, ok := gb.ClockSerial(0x33)
+	if !ok || out != 0x42 {
+		t.Errorf("ClockSerial returned %02X %t, want 42 true", out, ok)
+	}
+	if sb, sc := gb.mmu.readByte(0xFF01), gb.mmu.readByte(0xFF02); sb != 0x33 || sc != 0x7E || !serialRequested(gb) {
+		t.Errorf("SB is %02X and SC %02X, interrupt %t, want 33 7E and an interrupt", sb, sc, serialRequested(gb))
+	}
+	if _, ok := gb.ClockSerial(0x44); ok {
+		t.Error("clocked a byte in without a transfer")
+	}
+}
@@ -0,0 +1,212 @@
+package state
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Bump VERSION whenever the layout of a save state changes, and guard the
+// new fields with a Version() check so that older states still load.
+var (
+	MAGIC   = [4]uint8{'G', 'F', 'S', 'T'}
+	VERSION = uint16(1)
+
+	ErrMagic = errors.New("not a GameFella save state")
+)
+
+// Stream is implemented by both Writer and Reader, so each component
+// describes its state once and the same code saves and loads it.
+type Stream interface {
+	Uint8(v *uint8)
+	Uint16(v *uint16)
+	Uint32(v *uint32)
+	Int(v *int)
+	Int64(v *int64)
+	Bool(v *bool)
+	Bytes(v []uint8)
+	Uint32s(v []uint32)
+	Slice(v *[]uint8)
+	Version() uint16
+	Err() error
+}
+
+type Writer struct {
+	w   io.Writer
+	buf [8]uint8
+	err error
+}
+
+func NewWriter(w io.Writer) *Writer {
+	s := &Writer{w: w}
+	s.Bytes(MAGIC[:])
+	version := VERSION
+	s.Uint16(&version)
+	return s
+}
+
+func (s *Writer) write(b []uint8) {
+	if s.err == nil {
+		_, s.err = s.w.Write(b)
+	}
+}
+
+func (s *Writer) Uint8(v *uint8) {
+	s.buf[0] = *v
+	s.write(s.buf[:1])
+}
+
+func (s *Writer) Uint16(v *uint16) {
+	binary.LittleEndian.PutUint16(s.buf[:], *v)
+	s.write(s.buf[:2])
+}
+
+func (s *Writer) Uint32(v *uint32) {
+	binary.LittleEndian.PutUint32(s.buf[:], *v)
+	s.write(s.buf[:4])
+}
+
+func (s *Writer) Int(v *int) {
+	val := int64(*v)
+	s.Int64(&val)
+}
+
+func (s *Writer) Int64(v *int64) {
+	binary.LittleEndian.PutUint64(s.buf[:], uint64(*v))
+	s.write(s.buf[:8])
+}
+
+func (s *Writer) Bool(v *bool) {
+	s.buf[0] = 0
+	if *v {
+		s.buf[0] = 1
+	}
+	s.write(s.buf[:1])
+}
+
+func (s *Writer) Bytes(v []uint8) {
+	s.write(v)
+}
+
+func (s *Writer) Uint32s(v []uint32) {
+	b := make([]uint8, len(v)*4)
+	for i, val := range v {
+		binary.LittleEndian.PutUint32(b[i*4:], val)
+	}
+	s.write(b)
+}
+
+func (s *Writer) Slice(v *[]uint8) {
+	n := uint32(len(*v))
+	s.Uint32(&n)
+	s.write(*v)
+}
+
+func (s *Writer) Version() uint16 {
+	return VERSION
+}
+
+func (s *Writer) Err() error {
+	return s.err
+}
+
+type Reader struct {
+	r       io.Reader
+	buf     [8]uint8
+	version uint16
+	err     error
+}
+
+func NewReader(r io.Reader) (*Reader, error) {
+	s := &Reader{r: r}
+	var magic [4]uint8
+	s.Bytes(magic[:])
+	s.Uint16(&s.version)
+	if s.err != nil {
+		return nil, s.err
+	}
+	if magic != MAGIC {
+		return nil, ErrMagic
+	}
+	if s.version > VERSION {
+		return nil, fmt.Errorf("save state version %d is newer than supported version %d", s.version, VERSION)
+	}
+	return s, nil
+}
+
+func (s *Reader) read(b []uint8) {
+	if s.err == nil {
+		_, s.err = io.ReadFull(s.r, b)
+	}
+	if s.err != nil {
+		for i := range b {
+			b[i] = 0
+		}
+	}
+}
+
+func (s *Reader) Uint8(v *uint8) {
+	s.read(s.buf[:1])
+	*v = s.buf[0]
+}
+
+func (s *Reader) Uint16(v *uint16) {
+	s.read(s.buf[:2])
+	*v = binary.LittleEndian.Uint16(s.buf[:])
+}
+
+func (s *Reader) Uint32(v *uint32) {
+	s.read(s.buf[:4])
+	*v = binary.LittleEndian.Uint32(s.buf[:])
+}
+
+func (s *Reader) Int(v *int) {
+	var val int64
+	s.Int64(&val)
+	*v = int(val)
+}
+
+func (s *Reader) Int64(v *int64) {
+	s.read(s.buf[:8])
+	*v = int64(binary.LittleEndian.Uint64(s.buf[:]))
+}
+
+func (s *Reader) Bool(v *bool) {
+	s.read(s.buf[:1])
+	*v = s.buf[0] != 0
+}
+
+func (s *Reader) Bytes(v []uint8) {
+	s.read(v)
+}
+
+func (s *Reader) Uint32s(v []uint32) {
+	b := make([]uint8, len(v)*4)
+	s.read(b)
+	for i := range v {
+		v[i] = binary.LittleEndian.Uint32(b[i*4:])
+	}
+}
+
+func (s *Reader) Slice(v *[]uint8) {
+	var n uint32
+	s.Uint32(&n)
+	if s.err != nil {
+		return
+	}
+	if n > 1<<24 {
+		s.err = fmt.Errorf("save state slice of %d bytes is too large", n)
+		return
+	}
+	*v = make([]uint8, n)
+	s.read(*v)
+}
+
+func (s *Reader) Version() uint16 {
+	return s.version
+}
+
+func (s *Reader) Err() error {
+	return s.err
+}
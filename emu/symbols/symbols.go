@@ -0,0 +1,178 @@
+package symbols
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Labels only cover the memory region they are in, so that the last WRAM
+// label is not used to name an HRAM address.
+var REGIONS = []uint16{0x0000, 0x4000, 0x8000, 0xA000, 0xC000, 0xD000, 0xE000, 0xFE00, 0xFF00, 0xFF80}
+
+type Symbol struct {
+	Bank int
+	Addr uint16
+	Name string
+}
+
+// Table holds the labels of an RGBDS .sym file. A nil *Table is empty, so
+// callers do not need to check whether one was loaded.
+type Table struct {
+	sorted []Symbol
+	byName map[string]Symbol
+}
+
+// Discover looks for a .sym file next to the ROM, either game.gb.sym like
+// the battery save or game.sym as written by rgblink. It returns nil and an
+// empty path if there is none.
+func Discover(romPath string) (*Table, string, error) {
+	candidates := []string{
+		romPath + ".sym",
+		strings.TrimSuffix(romPath, filepath.Ext(romPath)) + ".sym",
+	}
+	for _, path := range candidates {
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		t, err := Load(path)
+		return t, path, err
+	}
+	return nil, "", nil
+}
+
+func Load(filename string) (*Table, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+// Parse reads "bank:addr label" lines. Everything after a ';' is a comment.
+func Parse(r io.Reader) (*Table, error) {
+	t := &Table{byName: map[string]Symbol{}}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if i := strings.Index(text, ";"); i >= 0 {
+			text = text[:i]
+		}
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected bank:addr label", line)
+		}
+
+		parts := strings.Split(fields[0], ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("line %d: expected bank:addr, got %q", line, fields[0])
+		}
+		bank, err := strconv.ParseUint(parts[0], 16, 16)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid bank %q", line, parts[0])
+		}
+		addr, err := strconv.ParseUint(parts[1], 16, 16)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid address %q", line, parts[1])
+		}
+
+		sym := Symbol{Bank: int(bank), Addr: uint16(addr), Name: fields[1]}
+		t.sorted = append(t.sorted, sym)
+		t.byName[sym.Name] = sym
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(t.sorted, func(i, j int) bool {
+		a, b := t.sorted[i], t.sorted[j]
+		if a.Bank != b.Bank {
+			return a.Bank < b.Bank
+		}
+		return a.Addr < b.Addr
+	})
+	return t, nil
+}
+
+func (t *Table) Len() int {
+	if t == nil {
+		return 0
+	}
+	return len(t.sorted)
+}
+
+// Find returns the symbol called name.
+func (t *Table) Find(name string) (Symbol, bool) {
+	if t == nil {
+		return Symbol{}, false
+	}
+	sym, ok := t.byName[name]
+	return sym, ok
+}
+
+// Lookup returns the first label at exactly bank:addr, or "".
+func (t *Table) Lookup(bank int, addr uint16) string {
+	sym, ok := t.nearest(bank, addr)
+	if !ok || sym.Addr != addr {
+		return ""
+	}
+	return sym.Name
+}
+
+// Describe names bank:addr as a label plus an offset, e.g. "Main.loop+3",
+// using the closest label before it in the same memory region. It returns
+// "" if there is none.
+func (t *Table) Describe(bank int, addr uint16) string {
+	sym, ok := t.nearest(bank, addr)
+	if !ok {
+		return ""
+	}
+	if sym.Addr == addr {
+		return sym.Name
+	}
+	return fmt.Sprintf("%s+%X", sym.Name, addr-sym.Addr)
+}
+
+func (t *Table) nearest(bank int, addr uint16) (Symbol, bool) {
+	if t == nil {
+		return Symbol{}, false
+	}
+
+	// Find the first symbol after bank:addr, then walk back to the first
+	// label of the closest address.
+	i := sort.Search(len(t.sorted), func(i int) bool {
+		s := t.sorted[i]
+		return s.Bank > bank || (s.Bank == bank && s.Addr > addr)
+	})
+	if i == 0 {
+		return Symbol{}, false
+	}
+	sym := t.sorted[i-1]
+	for i > 1 && t.sorted[i-2].Bank == sym.Bank && t.sorted[i-2].Addr == sym.Addr {
+		i--
+		sym = t.sorted[i-1]
+	}
+	if sym.Bank != bank || region(sym.Addr) != region(addr) {
+		return Symbol{}, false
+	}
+	return sym, true
+}
+
+func region(addr uint16) int {
+	r := 0
+	for i, start := range REGIONS {
+		if addr >= start {
+			r = i
+		}
+	}
+	return r
+}
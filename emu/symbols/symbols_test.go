@@ -0,0 +1,102 @@
+package symbols
+
+import (
+	"strings"
+	"testing"
+)
+
+var SYM_FILE = `; File generated by rgblink
+00:0150 Main
+00:0150 Start
+00:0158 Main.loop
+01:4000 Bank1
+02:4000 Bank2
+00:A000 Save0
+01:A000 Save1
+00:C000 wCounter
+00:C010 wBuffer
+00:FF80 hTemp
+`
+
+func TestParse(t *testing.T) {
+	table, err := Parse(strings.NewReader(SYM_FILE))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if table.Len() != 10 {
+		t.Errorf("Len is %d, want 10", table.Len())
+	}
+	sym, ok := table.Find("Main.loop")
+	if !ok || sym != (Symbol{Bank: 0, Addr: 0x0158, Name: "Main.loop"}) {
+		t.Errorf("Find(Main.loop) is %v, %t", sym, ok)
+	}
+	if _, ok := table.Find("Missing"); ok {
+		t.Error("Find(Missing) found a symbol")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		text string
+		err  string
+	}{
+		{"00:0150", "line 1: expected bank:addr label"},
+		{"00:0150 Main extra", "line 1: expected bank:addr label"},
+		{"\n0150 Main", `line 2: expected bank:addr, got "0150"`},
+		{"XY:0150 Main", `line 1: invalid bank "XY"`},
+		{"00:Z150 Main", `line 1: invalid address "Z150"`},
+	}
+	for _, test := range tests {
+		_, err := Parse(strings.NewReader(test.text))
+		if err == nil || err.Error() != test.err {
+			t.Errorf("Parse(%q) error is %v, want %s", test.text, err, test.err)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	table, err := Parse(strings.NewReader(SYM_FILE))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		bank     int
+		addr     uint16
+		lookup   string
+		describe string
+	}{
+		// The first label of an address wins.
+		{0, 0x0150, "Main", "Main"},
+		{0, 0x015A, "", "Main.loop+2"},
+		{0, 0x0100, "", ""},
+		// ROM and cartridge RAM labels only match their own bank.
+		{1, 0x4000, "Bank1", "Bank1"},
+		{2, 0x4010, "", "Bank2+10"},
+		{3, 0x4000, "", ""},
+		{0, 0xA000, "Save0", "Save0"},
+		{1, 0xA004, "", "Save1+4"},
+		{2, 0xA000, "", ""},
+		// Labels do not reach into the next memory region.
+		{0, 0xC020, "", "wBuffer+10"},
+		{0, 0xD000, "", ""},
+		{0, 0xFF81, "", "hTemp+1"},
+	}
+	for _, test := range tests {
+		if got := table.Lookup(test.bank, test.addr); got != test.lookup {
+			t.Errorf("Lookup(%02X:%04X) is %q, want %q", test.bank, test.addr, got, test.lookup)
+		}
+		if got := table.Describe(test.bank, test.addr); got != test.describe {
+			t.Errorf("Describe(%02X:%04X) is %q, want %q", test.bank, test.addr, got, test.describe)
+		}
+	}
+}
+
+func TestNilTable(t *testing.T) {
+	var table *Table
+	if table.Len() != 0 || table.Lookup(0, 0x0150) != "" || table.Describe(0, 0x0150) != "" {
+		t.Error("a nil Table is not empty")
+	}
+	if _, ok := table.Find("Main"); ok {
+		t.Error("a nil Table found a symbol")
+	}
+}
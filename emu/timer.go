@@ -1,68 +1,110 @@
 package emu
 
-import "github.com/is386/GoBoy/emu/bits"
+import (
+	"github.com/is386/GoBoy/emu/bits"
+	"github.com/is386/GoBoy/emu/state"
+)
 
 var (
-	FREQ_MAP = map[uint8]int{
-		0: 1024,
-		1: 16,
-		2: 64,
-		3: 256,
+	// TIMER_BITS is the bit of the system counter that clocks TIMA for each
+	// frequency in TAC. TIMA goes up when the bit falls from 1 to 0.
+	TIMER_BITS = [4]uint{
+		0: 9,
+		1: 3,
+		2: 5,
+		3: 7,
 	}
 )
 
+// Timer is the 16-bit system counter, which goes up by 4 every M-cycle, and
+// the timer clocked by it. DIV is the upper byte of the counter.
 type Timer struct {
-	gb     *GameBoy
-	divCyc int
-	count  int
+	gb      *GameBoy
+	counter uint16
+
+	// TIMA reads 0 for the M-cycle after it overflows, then is reloaded from
+	// TMA. A write to TIMA in that M-cycle cancels the reload; in the M-cycle
+	// of the reload, writes to TIMA are ignored and writes to TMA also go to
+	// TIMA.
+	overflow  bool
+	reloading bool
 }
 
 func NewTimer(gb *GameBoy) *Timer {
-	return &Timer{gb: gb}
+	return &Timer{gb: gb, counter: uint16(gb.mmu.readHRAM(DIV)) << 8}
 }
 
-func (t *Timer) update(cyc int) {
-	t.updateDiv(cyc)
-	if t.isTimerEnabled() {
-		t.count += cyc
-		freq := t.getTimerFreq()
-		for t.count >= freq {
-			t.count -= freq
-			t.updateTima()
-		}
-	}
+func (t *Timer) serialize(s state.Stream) {
+	s.Uint16(&t.counter)
+	s.Bool(&t.overflow)
+	s.Bool(&t.reloading)
 }
 
-func (t *Timer) updateDiv(cyc int) {
-	t.divCyc += cyc
-	if t.divCyc >= 255 {
-		t.divCyc -= 255
-		t.gb.mmu.incrDiv()
+// update runs the timer for one M-cycle of cyc clocks.
+func (t *Timer) update(cyc int) {
+	t.reloading = false
+	if t.overflow {
+		t.overflow = false
+		t.reloading = true
+		t.gb.mmu.HRAM[TIMA] = t.gb.mmu.HRAM[TMA]
+		t.gb.mmu.writeInterrupt(INT_TIMER)
 	}
+	t.setCounter(t.counter + uint16(cyc))
 }
 
-func (t *Timer) isTimerEnabled() bool {
-	return bits.Test(t.gb.mmu.readHRAM(TAC), 2)
+// signal is the input of the falling edge detector that clocks TIMA.
+func (t *Timer) signal() bool {
+	tac := t.gb.mmu.HRAM[TAC]
+	return bits.Test(tac, 2) && (t.counter>>TIMER_BITS[tac&0x3])&1 == 1
 }
 
-func (t *Timer) getTimerFreq() int {
-	return FREQ_MAP[t.gb.mmu.readHRAM(TAC)&0x3]
+// setCounter sets the system counter, and clocks TIMA if that takes the
+// signal low. The serial port's internal clock also comes from the counter.
+func (t *Timer) setCounter(val uint16) {
+	old := t.signal()
+	t.gb.serial.update(t.counter, val)
+	t.counter = val
+	t.gb.mmu.HRAM[DIV] = uint8(val >> 8)
+	if old && !t.signal() {
+		t.incrTima()
+	}
 }
 
-func (t *Timer) updateTima() {
-	tima := t.gb.mmu.readHRAM(TIMA)
-	if tima == 0xFF {
-		t.gb.mmu.writeHRAM(TIMA, t.gb.mmu.readHRAM(TMA))
-		t.gb.mmu.writeInterrupt(INT_TIMER)
+func (t *Timer) incrTima() {
+	if t.gb.mmu.HRAM[TIMA] == 0xFF {
+		t.gb.mmu.HRAM[TIMA] = 0
+		t.overflow = true
 	} else {
 		t.gb.mmu.incrTima()
 	}
 }
 
-func (t *Timer) resetTimer() {
-	t.count = 0
+// writeDiv resets the system counter, whatever is written.
+func (t *Timer) writeDiv() {
+	t.setCounter(0)
 }
 
-func (t *Timer) resetDivCyc() {
-	t.divCyc = 0
+// writeTac changes the frequency or enables the timer. Like resetting the
+// counter, this can take the signal low and clock TIMA.
+func (t *Timer) writeTac(val uint8) {
+	old := t.signal()
+	t.gb.mmu.HRAM[TAC] = val | 0xF8
+	if old && !t.signal() {
+		t.incrTima()
+	}
+}
+
+func (t *Timer) writeTima(val uint8) {
+	if t.reloading {
+		return
+	}
+	t.overflow = false
+	t.gb.mmu.HRAM[TIMA] = val
+}
+
+func (t *Timer) writeTma(val uint8) {
+	t.gb.mmu.HRAM[TMA] = val
+	if t.reloading {
+		t.gb.mmu.HRAM[TIMA] = val
+	}
 }
@@ -0,0 +1,141 @@
+package emu
+
+import "testing"
+
+// newTimerGameBoy returns a Game Boy whose system counter and TAC are set
+// directly, with TIMA at 0 and no interrupt requested.
+func newTimerGameBoy(t *testing.T, counter uint16, tac uint8) *GameBoy {
+	gb := newTestGameBoy(t, []uint8{0x18, 0xFE}, false)
+	gb.timer.counter = counter
+	gb.mmu.HRAM[TAC] = tac | 0xF8
+	gb.mmu.HRAM[TIMA] = 0
+	gb.mmu.HRAM[0x0F] = 0xE0
+	return gb
+}
+
+func timerRequested(gb *GameBoy) bool {
+	return gb.mmu.HRAM[0x0F]&(1<<INT_TIMER) != 0
+}
+
+func TestTimerDivWrite(t *testing.T) {
+	tests := []struct {
+		name    string
+		counter uint16
+		tac     uint8
+		tima    uint8
+	}{
+		// Resetting the counter takes the selected bit low.
+		{"bit 3 high", 0x0008, 0x05, 1},
+		{"bit 3 low", 0x0004, 0x05, 0},
+		{"bit 9 high", 0x0200, 0x04, 1},
+		{"other bits high", 0xFDF7, 0x04, 0},
+		{"disabled", 0x0008, 0x01, 0},
+	}
+	for _, test := range tests {
+		gb := newTimerGameBoy(t, test.counter, test.tac)
+		gb.timer.writeDiv()
+		if gb.timer.counter != 0 || gb.mmu.HRAM[DIV] != 0 {
+			t.Errorf("%s: counter is %04X after a DIV write", test.name, gb.timer.counter)
+		}
+		if tima := gb.mmu.HRAM[TIMA]; tima != test.tima {
+			t.Errorf("%s: TIMA is %d, want %d", test.name, tima, test.tima)
+		}
+	}
+}
+
+func TestTimerTacWrite(t *testing.T) {
+	tests := []struct {
+		name     string
+		counter  uint16
+		old, new uint8
+		tima     uint8
+	}{
+		{"disable with bit high", 0x0008, 0x05, 0x01, 1},
+		{"disable with bit low", 0x0004, 0x05, 0x01, 0},
+		{"to a low bit", 0x0008, 0x05, 0x06, 1},
+		{"to a high bit", 0x0020, 0x05, 0x06, 0},
+		{"enable", 0x0008, 0x01, 0x05, 0},
+		{"same frequency", 0x0008, 0x05, 0x05, 0},
+	}
+	for _, test := range tests {
+		gb := newTimerGameBoy(t, test.counter, test.old)
+		gb.timer.writeTac(test.new)
+		if tima := gb.mmu.HRAM[TIMA]; tima != test.tima {
+			t.Errorf("%s: TIMA is %d, want %d", test.name, tima, test.tima)
+		}
+		if tac := gb.mmu.HRAM[TAC]; tac != test.new|0xF8 {
+			t.Errorf("%s: TAC is %02X, want %02X", test.name, tac, test.new|0xF8)
+		}
+	}
+}
+
+// overflowTimer returns a Game Boy whose TIMA overflowed in the last M-cycle.
+func overflowTimer(t *testing.T) *GameBoy {
+	gb := newTimerGameBoy(t, 0x000C, 0x05)
+	gb.mmu.HRAM[TIMA] = 0xFF
+	gb.mmu.HRAM[TMA] = 0x42
+	gb.timer.update(4)
+	return gb
+}
+
+func TestTimerOverflow(t *testing.T) {
+	gb := overflowTimer(t)
+	// TIMA reads 0 for one M-cycle before it is reloaded.
+	if tima := gb.mmu.HRAM[TIMA]; tima != 0 || timerRequested(gb) {
+		t.Fatalf("TIMA is %02X right after the overflow, interrupt %t", tima, timerRequested(gb))
+	}
+	gb.timer.update(4)
+	if tima := gb.mmu.HRAM[TIMA]; tima != 0x42 || !timerRequested(gb) {
+		t.Fatalf("TIMA is %02X after the reload, interrupt %t", tima, timerRequested(gb))
+	}
+	gb.timer.update(4)
+	if gb.timer.reloading {
+		t.Error("still reloading after the next M-cycle")
+	}
+}
+
+func TestTimerWriteInOverflowCycle(t *testing.T) {
+	// Writing TIMA right after the overflow cancels the reload and the
+	// interrupt.
+	gb := overflowTimer(t)
+	gb.timer.writeTima(0x33)
+	gb.timer.update(4)
+	if tima := gb.mmu.HRAM[TIMA]; tima != 0x33 || timerRequested(gb) {
+		t.Errorf("TIMA is %02X, interrupt %t, want 33 and no interrupt", tima, timerRequested(gb))
+	}
+
+	// Writing TMA then is picked up by the reload.
+	gb = overflowTimer(t)
+	gb.timer.writeTma(0x55)
+	gb.timer.update(4)
+	if tima := gb.mmu.HRAM[TIMA]; tima != 0x55 || !timerRequested(gb) {
+		t.Errorf("TIMA is %02X, interrupt %t, want 55 and an interrupt", tima, timerRequested(gb))
+	}
+}
+
+func TestTimerWriteInReloadCycle(t *testing.T) {
+	// Writes to TIMA in the M-cycle of the reload are ignored.
+	gb := overflowTimer(t)
+	gb.timer.update(4)
+	gb.timer.writeTima(0x33)
+	if tima := gb.mmu.HRAM[TIMA]; tima != 0x42 {
+		t.Errorf("TIMA is %02X after a write in the reload cycle, want 42", tima)
+	}
+
+	// Writes to TMA go to TIMA as well.
+	gb = overflowTimer(t)
+	gb.timer.update(4)
+	gb.timer.writeTma(0x55)
+	if tima, tma := gb.mmu.HRAM[TIMA], gb.mmu.HRAM[TMA]; tima != 0x55 || tma != 0x55 {
+		t.Errorf("TIMA is %02X and TMA %02X after a TMA write in the reload cycle, want 55", tima, tma)
+	}
+
+	// A cycle later, TMA writes only go to TMA.
+	gb = overflowTimer(t)
+	gb.timer.update(4)
+	gb.timer.update(4)
+	gb.timer.writeTma(0x55)
+	if tima := gb.mmu.HRAM[TIMA]; tima != 0x42 {
+		t.Errorf("TIMA is %02X after a TMA write after the reload, want 42", tima)
+	}
+}
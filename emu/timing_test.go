@@ -0,0 +1,118 @@
+package emu
+
+import (
+	"strings"
+	"testing"
+)
+
+// timedAccess is a read or write of the CPU, with the M-cycle of the
+// instruction it happened in and the timer and PPU clocks it saw.
+type timedAccess struct {
+	cycle int
+	write bool
+	timer uint16
+	ppu   int
+}
+
+// accessRecorder is a memory hook that records the CPU's reads and writes.
+type accessRecorder struct {
+	gb       *GameBoy
+	accesses []timedAccess
+}
+
+func (r *accessRecorder) record(write bool) {
+	r.accesses = append(r.accesses, timedAccess{r.gb.cpu.ticks, write, r.gb.timer.counter, r.gb.ppu.cyc})
+}
+
+func (r *accessRecorder) memoryRead(addr uint16, val uint8) {
+	r.record(false)
+}
+
+func (r *accessRecorder) memoryWrite(addr uint16, old, val uint8) {
+	r.record(true)
+}
+
+func TestAccessTiming(t *testing.T) {
+	// The M-cycles of each instruction that read (r) or write (w) memory,
+	// not counting the opcode and operand fetches.
+	tests := []struct {
+		name     string
+		code     []uint8
+		accesses string
+		cycles   int
+	}{
+		{"LD A,(HL)", []uint8{0x7E}, "-r", 2},
+		{"LD (HL),A", []uint8{0x77}, "-w", 2},
+		{"LDH A,(n)", []uint8{0xF0, 0x80}, "--r", 3},
+		{"LD A,(nn)", []uint8{0xFA, 0x00, 0xC0}, "---r", 4},
+		{"LD (nn),A", []uint8{0xEA, 0x00, 0xC0}, "---w", 4},
+		{"BIT 0,(HL)", []uint8{0xCB, 0x46}, "--r", 3},
+		{"INC (HL)", []uint8{0x34}, "-rw", 3},
+		{"PUSH BC", []uint8{0xC5}, "--ww", 4},
+		{"POP BC", []uint8{0xC1}, "-rr", 3},
+		{"LD (nn),SP", []uint8{0x08, 0x00, 0xC0}, "---ww", 5},
+		{"CALL nn", []uint8{0xCD, 0x00, 0x02}, "----ww", 6},
+		{"RET", []uint8{0xC9}, "-rr-", 4},
+		{"RST $38", []uint8{0xFF}, "--ww", 4},
+	}
+	for _, cgb := range []bool{false, true} {
+		for _, test := range tests {
+			gb := newTestGameBoy(t, test.code, cgb)
+			stepTo(t, gb, 0x0150)
+			// Double speed halves the PPU's clocks, but not the timer's.
+			clock := 4
+			if cgb {
+				gb.speed = 1
+				clock = 2
+			}
+			gb.cpu.reg.setHL(0xC000)
+			gb.cpu.sp = 0xD000
+			gb.timer.counter = 0x1000
+			gb.ppu.cyc = 400
+			r := &accessRecorder{gb: gb}
+			gb.mmu.hook = r
+			if cyc := gb.step(); cyc != test.cycles*clock {
+				t.Errorf("%s (CGB %t): took %d clocks, want %d", test.name, cgb, cyc, test.cycles*clock)
+			}
+
+			accesses := []uint8(strings.Repeat("-", test.cycles))
+			for _, a := range r.accesses {
+				kind := uint8('r')
+				if a.write {
+					kind = 'w'
+				}
+				accesses[a.cycle-1] = kind
+
+				// An access in M-cycle N sees the machine N M-cycles on.
+				if a.timer != 0x1000+uint16(4*a.cycle) || a.ppu != 400-clock*a.cycle {
+					t.Errorf("%s (CGB %t): access in M-cycle %d sees timer %04X and PPU %d", test.name, cgb, a.cycle, a.timer, a.ppu)
+				}
+			}
+			if string(accesses) != test.accesses {
+				t.Errorf("%s (CGB %t): accesses %s, want %s", test.name, cgb, accesses, test.accesses)
+			}
+		}
+	}
+}
+
+// TestDivReadTiming checks that a read of DIV sees the increment from the
+// tick of its own M-cycle.
+func TestDivReadTiming(t *testing.T) {
+	// LD A,($FF04) reads DIV in its 4th M-cycle.
+	tests := []struct {
+		counter uint16
+		div     uint8
+	}{
+		{0x00F0, 1},
+		{0x00EC, 0},
+	}
+	for _, test := range tests {
+		gb := newTestGameBoy(t, []uint8{0xFA, 0x04, 0xFF}, false)
+		stepTo(t, gb, 0x0150)
+		gb.timer.counter = test.counter
+		gb.step()
+		if gb.cpu.reg.A != test.div {
+			t.Errorf("counter %04X: read DIV %d, want %d", test.counter, gb.cpu.reg.A, test.div)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package emu
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/is386/GoBoy/emu/disasm"
+)
+
+var (
+	TRACE_MAGIC   = [4]uint8{'G', 'F', 'T', 'R'}
+	TRACE_VERSION = uint8(1)
+)
+
+// TraceTrigger starts or stops a trace when the CPU reaches pc, or at the
+// start of frame if pc is nil.
+type TraceTrigger struct {
+	pc    *Breakpoint
+	frame uint64
+}
+
+// ParseTraceTrigger parses a label, "[bank:]addr" or "frame:n".
+func (gb *GameBoy) ParseTraceTrigger(s string) (*TraceTrigger, error) {
+	if strings.HasPrefix(s, "frame:") {
+		frame, err := strconv.ParseUint(s[6:], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid frame %q", s[6:])
+		}
+		return &TraceTrigger{frame: frame}, nil
+	}
+	b, err := gb.parseBreakpoint(s)
+	if err != nil {
+		return nil, err
+	}
+	return &TraceTrigger{pc: &b}, nil
+}
+
+// Tracer logs the CPU state before every instruction. The text format is the
+// one used by Gameboy Doctor:
+//
+//	A:01 F:B0 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0100 PCMEM:00,C3,13,02
+//
+// With labels, the closest label to PC is appended as " ; Main.loop+3", and
+// with disassembly the instruction as " ; LD A,(HL)", or " ; Main.loop+3: LD
+// A,(HL)" with both. These are off by default since Gameboy Doctor expects
+// the exact format.
+//
+// The binary format is a magic and version followed by a 16 byte record per
+// instruction:
+//
+//	A, F, B, C, D, E, H, L uint8, SP, PC uint16 (little endian), PCMEM [4]uint8
+type Tracer struct {
+	gb          *GameBoy
+	file        *os.File
+	w           *bufio.Writer
+	binary      bool
+	labels      bool
+	disasm      bool
+	start, stop *TraceTrigger
+	active      bool
+}
+
+type TraceOptions struct {
+	Binary      bool
+	Labels      bool
+	Disasm      bool
+	Start, Stop *TraceTrigger
+}
+
+// StartTrace writes a trace to filename. Tracing begins immediately if Start
+// is nil, and runs until the emulator exits if Stop is nil.
+func (gb *GameBoy) StartTrace(filename string, opts TraceOptions) error {
+	gb.StopTrace()
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	t := &Tracer{gb: gb, file: f, w: bufio.NewWriter(f), binary: opts.Binary, labels: opts.Labels,
+		disasm: opts.Disasm, start: opts.Start, stop: opts.Stop, active: opts.Start == nil}
+	if t.binary {
+		t.w.Write(TRACE_MAGIC[:])
+		t.w.WriteByte(TRACE_VERSION)
+	}
+	gb.tracer = t
+	return nil
+}
+
+func (gb *GameBoy) StopTrace() {
+	if gb.tracer == nil {
+		return
+	}
+	gb.tracer.w.Flush()
+	gb.tracer.file.Close()
+	gb.tracer = nil
+}
+
+func (t *Tracer) matches(trigger *TraceTrigger, pc uint16) bool {
+	if trigger.pc == nil {
+		return t.gb.frames >= trigger.frame
+	}
+	b := trigger.pc
+	return b.addr == pc && (b.bank < 0 || b.bank == t.gb.bankOf(pc))
+}
+
+// trace is called before every instruction.
+func (t *Tracer) trace() {
+	c := t.gb.cpu
+	if !t.active {
+		if !t.matches(t.start, c.pc) {
+			return
+		}
+		t.active = true
+	}
+	if t.stop != nil && t.matches(t.stop, c.pc) {
+		t.gb.StopTrace()
+		return
+	}
+
+	m := t.gb.mmu
+	f := c.flags.getF()
+	if t.binary {
+		t.w.Write([]uint8{
+			c.reg.A, f, c.reg.B, c.reg.C, c.reg.D, c.reg.E, c.reg.H, c.reg.L,
+			uint8(c.sp), uint8(c.sp >> 8), uint8(c.pc), uint8(c.pc >> 8),
+			m.readByte(c.pc), m.readByte(c.pc + 1), m.readByte(c.pc + 2), m.readByte(c.pc + 3),
+		})
+		return
+	}
+	fmt.Fprintf(t.w, "A:%02X F:%02X B:%02X C:%02X D:%02X E:%02X H:%02X L:%02X SP:%04X PC:%04X PCMEM:%02X,%02X,%02X,%02X",
+		c.reg.A, f, c.reg.B, c.reg.C, c.reg.D, c.reg.E, c.reg.H, c.reg.L, c.sp, c.pc,
+		m.readByte(c.pc), m.readByte(c.pc+1), m.readByte(c.pc+2), m.readByte(c.pc+3))
+	var comment []string
+	if t.labels {
+		if label := t.gb.describe(c.pc); label != "" {
+			comment = append(comment, label)
+		}
+	}
+	if t.disasm {
+		comment = append(comment, disasm.Decode(m.readByte, t.gb.labels(), c.pc).Mnemonic)
+	}
+	if len(comment) > 0 {
+		fmt.Fprintf(t.w, " ; %s", strings.Join(comment, ": "))
+	}
+	t.w.WriteByte('\n')
+}
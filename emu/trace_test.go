@@ -0,0 +1,110 @@
+package emu
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TRACE_LOOP loads A, then increments B forever.
+var TRACE_LOOP = []uint8{
+	0x3E, 0x42, // LD A,$42
+	0x04,       // INC B
+	0x18, 0xFD, // JR -3
+}
+
+// runTrace traces steps instructions of TRACE_LOOP and returns the trace.
+func runTrace(t *testing.T, opts TraceOptions, start, stop string, steps int) string {
+	gb := newTestGameBoy(t, TRACE_LOOP, false)
+	var err error
+	if start != "" {
+		if opts.Start, err = gb.ParseTraceTrigger(start); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stop != "" {
+		if opts.Stop, err = gb.ParseTraceTrigger(stop); err != nil {
+			t.Fatal(err)
+		}
+	}
+	filename := filepath.Join(t.TempDir(), "trace.log")
+	if err := gb.StartTrace(filename, opts); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < steps; i++ {
+		gb.step()
+	}
+	gb.StopTrace()
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestTraceDoctor(t *testing.T) {
+	want := `A:01 F:80 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0100 PCMEM:00,C3,50,01
+A:01 F:80 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0101 PCMEM:C3,50,01,00
+A:01 F:80 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0150 PCMEM:3E,42,04,18
+A:42 F:80 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0152 PCMEM:04,18,FD,00
+A:42 F:00 B:01 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0153 PCMEM:18,FD,00,00
+A:42 F:00 B:01 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0152 PCMEM:04,18,FD,00
+`
+	if got := runTrace(t, TraceOptions{}, "", "", 6); got != want {
+		t.Errorf("got trace:\n%s\nwant:\n%s", got, want)
+	}
+
+	got := runTrace(t, TraceOptions{Disasm: true}, "", "", 4)
+	if line := strings.Split(got, "\n")[3]; !strings.HasSuffix(line, "PCMEM:04,18,FD,00 ; INC B") {
+		t.Errorf("disassembled line is %q", line)
+	}
+}
+
+func TestTraceBinary(t *testing.T) {
+	want := []uint8{
+		'G', 'F', 'T', 'R', 1,
+		0x42, 0x80, 0x00, 0x13, 0x00, 0xD8, 0x01, 0x4D, 0xFE, 0xFF, 0x52, 0x01, 0x04, 0x18, 0xFD, 0x00,
+	}
+	// Tracing starts at 0152, the fourth instruction, so only it is traced.
+	got := runTrace(t, TraceOptions{Binary: true}, "0152", "", 4)
+	if !bytes.Equal([]uint8(got), want) {
+		t.Errorf("got % X, want % X", got, want)
+	}
+}
+
+func TestTraceTriggers(t *testing.T) {
+	tests := []struct {
+		start, stop string
+		steps       int
+		pcs         []string
+	}{
+		{"0152", "", 6, []string{"0152", "0153", "0152"}},
+		{"0150", "0153", 6, []string{"0150", "0152"}},
+		{"", "0150", 6, []string{"0100", "0101"}},
+		{"00:0152", "", 5, []string{"0152", "0153"}},
+		{"01:0152", "", 6, nil},
+		{"frame:1", "", 6, nil},
+		{"frame:0", "0101", 6, []string{"0100"}},
+	}
+	for _, test := range tests {
+		got := runTrace(t, TraceOptions{}, test.start, test.stop, test.steps)
+		var pcs []string
+		for _, line := range strings.Split(strings.TrimSuffix(got, "\n"), "\n") {
+			if i := strings.Index(line, "PC:"); i >= 0 {
+				pcs = append(pcs, line[i+3:i+7])
+			}
+		}
+		if strings.Join(pcs, " ") != strings.Join(test.pcs, " ") {
+			t.Errorf("start %q, stop %q: traced %v, want %v", test.start, test.stop, pcs, test.pcs)
+		}
+	}
+
+	gb := newTestGameBoy(t, TRACE_LOOP, false)
+	for _, s := range []string{"frame:x", "nowhere"} {
+		if _, err := gb.ParseTraceTrigger(s); err == nil {
+			t.Errorf("parsed trigger %q", s)
+		}
+	}
+}
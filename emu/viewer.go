@@ -0,0 +1,338 @@
+package emu
+
+import (
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"strings"
+)
+
+var (
+	VIEW_TILES    = "tiles"
+	VIEW_MAP0     = "map0"
+	VIEW_MAP1     = "map1"
+	VIEW_OAM      = "oam"
+	VIEW_PALETTES = "palettes"
+	VIEWS         = []string{VIEW_TILES, VIEW_MAP0, VIEW_MAP1, VIEW_OAM, VIEW_PALETTES}
+
+	// Attached views are redrawn every VIEW_INTERVAL frames.
+	VIEW_INTERVAL = 10
+
+	VIEW_BACKGROUND  uint32 = 0x303030
+	VIEW_TRANSPARENT uint32 = 0xFF00FF
+	VIEW_VIEWPORT    uint32 = 0xFF0000
+	VIEW_TEXT        uint32 = 0xFFFFFF
+)
+
+// ImageSink shows a debug view, e.g. in a window of its own.
+type ImageSink interface {
+	DrawImage(img *image.RGBA)
+}
+
+type attachedView struct {
+	name string
+	sink ImageSink
+}
+
+// AttachView redraws the view called name into sink while the game runs.
+func (gb *GameBoy) AttachView(name string, sink ImageSink) error {
+	if _, err := gb.RenderView(name); err != nil {
+		return err
+	}
+	gb.views = append(gb.views, attachedView{name: name, sink: sink})
+	return nil
+}
+
+func (gb *GameBoy) updateViews() {
+	if len(gb.views) == 0 || gb.frames%uint64(VIEW_INTERVAL) != 0 {
+		return
+	}
+	for _, v := range gb.views {
+		img, _ := gb.RenderView(v.name)
+		v.sink.DrawImage(img)
+	}
+}
+
+// RenderView draws one of VIEWS from the current VRAM, OAM and palettes.
+func (gb *GameBoy) RenderView(name string) (*image.RGBA, error) {
+	switch name {
+	case VIEW_TILES:
+		return gb.renderTiles(), nil
+	case VIEW_MAP0:
+		return gb.renderTileMap(0x9800), nil
+	case VIEW_MAP1:
+		return gb.renderTileMap(0x9C00), nil
+	case VIEW_OAM:
+		return gb.renderOAM(), nil
+	case VIEW_PALETTES:
+		return gb.renderPalettes(), nil
+	}
+	return nil, fmt.Errorf("unknown view %q, expected one of %s", name, strings.Join(VIEWS, ", "))
+}
+
+// SaveViews writes every view to prefix-<view>.png.
+func (gb *GameBoy) SaveViews(prefix string) error {
+	for _, name := range VIEWS {
+		img, _ := gb.RenderView(name)
+		f, err := os.Create(fmt.Sprintf("%s-%s.png", prefix, name))
+		if err != nil {
+			return err
+		}
+		if err := png.Encode(f, img); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newView(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	fillRect(img, 0, 0, w, h, VIEW_BACKGROUND)
+	return img
+}
+
+func setPixel(img *image.RGBA, x, y int, color uint32) {
+	if !(image.Point{x, y}).In(img.Rect) {
+		return
+	}
+	i := img.PixOffset(x, y)
+	img.Pix[i] = uint8(color >> 16)
+	img.Pix[i+1] = uint8(color >> 8)
+	img.Pix[i+2] = uint8(color)
+	img.Pix[i+3] = 0xFF
+}
+
+func fillRect(img *image.RGBA, x, y, w, h int, color uint32) {
+	for j := y; j < y+h; j++ {
+		for i := x; i < x+w; i++ {
+			setPixel(img, i, j, color)
+		}
+	}
+}
+
+// tileColorId returns the 2-bit color of pixel x, y of the tile at addr.
+func (gb *GameBoy) tileColorId(addr uint16, bank uint8, x, y int) uint8 {
+	lo := gb.mmu.readVRAM(addr+uint16(y*2), bank)
+	hi := gb.mmu.readVRAM(addr+uint16(y*2)+1, bank)
+	shift := uint(7 - x)
+	return ((hi>>shift)&1)<<1 | (lo>>shift)&1
+}
+
+// viewColor looks up a color the same way the PPU does.
+func (gb *GameBoy) viewColor(colorId, paletteAddr uint8, isSprite bool) uint32 {
+	p := gb.ppu
+	if gb.isDMGCart && gb.isCGB {
+		_, tmp := p.getDMGColor(colorId, paletteAddr)
+		return p.getCGBColor(tmp, 0, isSprite)
+	} else if gb.isCGB {
+		return p.getCGBColor(colorId, paletteAddr, isSprite)
+	}
+	color, _ := p.getDMGColor(colorId, paletteAddr)
+	return color
+}
+
+// renderTiles draws the 384 tiles of each VRAM bank, 16 per row, with bank 0
+// on the left and bank 1 on the right. Tiles are drawn in shades of gray
+// since they are not tied to a palette.
+func (gb *GameBoy) renderTiles() *image.RGBA {
+	img := newView(16*8*2+8, 24*8)
+	for bank := 0; bank < 2; bank++ {
+		for tile := 0; tile < 384; tile++ {
+			tx := bank*(16*8+8) + (tile%16)*8
+			ty := (tile / 16) * 8
+			for y := 0; y < 8; y++ {
+				for x := 0; x < 8; x++ {
+					colorId := gb.tileColorId(0x8000+uint16(tile*16), uint8(bank), x, y)
+					setPixel(img, tx+x, ty+y, COLORS[colorId])
+				}
+			}
+		}
+	}
+	return img
+}
+
+// renderTileMap draws a 32x32 tile map with the tile data area and CGB
+// attributes currently selected by LCDC, and outlines the part that SCX
+// and SCY scroll onto the screen.
+func (gb *GameBoy) renderTileMap(mapAddr uint16) *image.RGBA {
+	p := gb.ppu
+	img := newView(256, 256)
+	for ty := 0; ty < 32; ty++ {
+		for tx := 0; tx < 32; tx++ {
+			idAddr := mapAddr + uint16(ty*32+tx)
+			tileId := gb.mmu.readVRAM(idAddr, 0)
+			tileAddr := 0x8000 + uint16(tileId)*16
+			if !p.useFirstTileArea() {
+				tileAddr = uint16(0x9000 + int(int8(tileId))*16)
+			}
+
+			paletteAddr, bank := BGP, uint8(0)
+			flipX, flipY := false, false
+			if !gb.isDMGCart {
+				attrs := gb.mmu.readVRAM(idAddr, 1)
+				paletteAddr = p.getBGPalette(attrs)
+				bank = p.getBGVRAMBank(attrs)
+				flipX, flipY = p.isBGFlipX(attrs), p.isBGFlipY(attrs)
+			}
+
+			for y := 0; y < 8; y++ {
+				for x := 0; x < 8; x++ {
+					px, py := x, y
+					if flipX {
+						px = 7 - x
+					}
+					if flipY {
+						py = 7 - y
+					}
+					colorId := gb.tileColorId(tileAddr, bank, px, py)
+					setPixel(img, tx*8+x, ty*8+y, gb.viewColor(colorId, paletteAddr, false))
+				}
+			}
+		}
+	}
+
+	scx := int(gb.mmu.readHRAM(SCX))
+	scy := int(gb.mmu.readHRAM(SCY))
+	for i := 0; i < WIDTH; i++ {
+		setPixel(img, (scx+i)%256, scy, VIEW_VIEWPORT)
+		setPixel(img, (scx+i)%256, (scy+HEIGHT-1)%256, VIEW_VIEWPORT)
+	}
+	for i := 0; i < HEIGHT; i++ {
+		setPixel(img, scx, (scy+i)%256, VIEW_VIEWPORT)
+		setPixel(img, (scx+WIDTH-1)%256, (scy+i)%256, VIEW_VIEWPORT)
+	}
+	return img
+}
+
+// Sprite is an OAM entry with its attributes decoded.
+type Sprite struct {
+	Index        int
+	X, Y         int
+	Tile         uint8
+	Palette      int
+	Bank         int
+	FlipX, FlipY bool
+	BehindBG     bool
+}
+
+// Sprites decodes the 40 OAM entries. X and Y are screen coordinates, so
+// hidden sprites have X < -7 or Y < -15. Palette is the CGB palette, or
+// the DMG OBP register (0 or 1).
+func (gb *GameBoy) Sprites() []Sprite {
+	p := gb.ppu
+	sprites := make([]Sprite, 40)
+	for i := range sprites {
+		oam := gb.mmu.OAM[i*4 : i*4+4]
+		attrs := oam[3]
+		s := Sprite{
+			Index:    i,
+			Y:        int(oam[0]) - 16,
+			X:        int(oam[1]) - 8,
+			Tile:     oam[2],
+			FlipX:    p.isSpriteFlipX(attrs),
+			FlipY:    p.isSpriteFlipY(attrs),
+			BehindBG: !p.spriteHasPriority(attrs),
+		}
+		if gb.isCGB && !gb.isDMGCart {
+			s.Palette = int(p.getSpriteCGBPalette(attrs))
+			s.Bank = int(p.getSpriteVRAMBank(attrs))
+		} else if !p.useFirstPalette(attrs) {
+			s.Palette = 1
+		}
+		sprites[i] = s
+	}
+	return sprites
+}
+
+func (s Sprite) String() string {
+	flags := ""
+	if s.FlipX {
+		flags += " FX"
+	}
+	if s.FlipY {
+		flags += " FY"
+	}
+	if s.BehindBG {
+		flags += " BG"
+	}
+	return fmt.Sprintf("%02d X:%02X Y:%02X T:%02X P:%d B:%d%s",
+		s.Index, uint8(s.X+8), uint8(s.Y+16), s.Tile, s.Palette, s.Bank, flags)
+}
+
+// renderOAM draws the 40 sprites in two columns, each followed by its
+// decoded attributes. Transparent pixels are magenta.
+func (gb *GameBoy) renderOAM() *image.RGBA {
+	p := gb.ppu
+	height := 8
+	if p.is8x16Sprite() {
+		height = 16
+	}
+
+	colWidth, rowHeight := 160, 18
+	img := newView(colWidth*2, rowHeight*20)
+	for _, s := range gb.Sprites() {
+		ox := (s.Index/20)*colWidth + 1
+		oy := (s.Index%20)*rowHeight + 1
+
+		tile := s.Tile
+		if height == 16 {
+			tile &^= 1
+		}
+		paletteAddr := uint8(s.Palette)
+		if !gb.isCGB || gb.isDMGCart {
+			paletteAddr = OBP0 + uint8(s.Palette)
+		}
+
+		for y := 0; y < height; y++ {
+			for x := 0; x < 8; x++ {
+				px, py := x, y
+				if s.FlipX {
+					px = 7 - x
+				}
+				if s.FlipY {
+					py = height - 1 - y
+				}
+				colorId := gb.tileColorId(0x8000+uint16(tile)*16, uint8(s.Bank), px, py)
+				color := VIEW_TRANSPARENT
+				if colorId != 0 {
+					color = gb.viewColor(colorId, paletteAddr, true)
+				}
+				setPixel(img, ox+x, oy+y, color)
+			}
+		}
+		drawText(img, ox+12, oy+5, s.String(), VIEW_TEXT)
+	}
+	return img
+}
+
+// renderPalettes draws the 8 CGB background palettes on the left and the 8
+// sprite palettes on the right, one palette per row. On the DMG it draws
+// BGP, OBP0 and OBP1 instead.
+func (gb *GameBoy) renderPalettes() *image.RGBA {
+	size := 16
+	img := newView(size*9, size*8)
+	if !gb.isCGB {
+		for row, reg := range []uint8{BGP, OBP0, OBP1} {
+			for colorId := uint8(0); colorId < 4; colorId++ {
+				color, _ := gb.ppu.getDMGColor(colorId, reg)
+				fillRect(img, int(colorId)*size, row*size, size, size, color)
+			}
+		}
+		return img
+	}
+
+	for side := 0; side < 2; side++ {
+		for palette := uint8(0); palette < 8; palette++ {
+			for colorId := uint8(0); colorId < 4; colorId++ {
+				color := gb.ppu.getCGBColor(colorId, palette, side == 1)
+				fillRect(img, side*size*5+int(colorId)*size, int(palette)*size, size, size, color)
+			}
+		}
+	}
+	return img
+}
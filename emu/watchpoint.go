@@ -0,0 +1,252 @@
+package emu
+
+import (
+	"fmt"
+	"strings"
+)
+
+var (
+	WATCH_READ      uint8 = 0x01
+	WATCH_WRITE     uint8 = 0x02
+	WATCH_EXEC      uint8 = 0x04
+	WATCH_CHANGE    uint8 = 0x08
+	WATCH_EQUALS    uint8 = 0x10
+	WATCH_KINDS           = map[string]uint8{"r": WATCH_READ, "w": WATCH_WRITE, "rw": WATCH_READ | WATCH_WRITE, "x": WATCH_EXEC, "chg": WATCH_CHANGE}
+	WATCH_OPERATORS       = []string{"==", "!=", "<=", ">=", "<", ">"}
+)
+
+// Watchpoint stops or logs when the CPU accesses memory in [start, end]. A
+// bank of -1 matches any bank.
+type Watchpoint struct {
+	kind       uint8
+	bank       int
+	start, end uint16
+	value      uint8
+	cond       *Condition
+	log        bool
+}
+
+// Condition compares a register, or the accessed value (val) and the value
+// before a write (old), against a constant.
+type Condition struct {
+	lhs string
+	op  string
+	rhs int
+}
+
+func (w Watchpoint) String() string {
+	kind := ""
+	switch w.kind {
+	case WATCH_EQUALS:
+		kind = fmt.Sprintf("=%02X", w.value)
+	default:
+		for name, k := range WATCH_KINDS {
+			if k == w.kind {
+				kind = name
+			}
+		}
+	}
+
+	where := fmt.Sprintf("%04X", w.start)
+	if w.end != w.start {
+		where += fmt.Sprintf("-%04X", w.end)
+	}
+	if w.bank >= 0 {
+		where = fmt.Sprintf("%02X:%s", w.bank, where)
+	}
+
+	s := kind + " " + where
+	if w.cond != nil {
+		s += fmt.Sprintf(" if %s %s %X", w.cond.lhs, w.cond.op, w.cond.rhs)
+	}
+	if w.log {
+		s += " log"
+	}
+	return s
+}
+
+func (d *Debugger) memoryRead(addr uint16, val uint8) {
+	d.checkWatchpoints(WATCH_READ, addr, val, val)
+}
+
+func (d *Debugger) memoryWrite(addr uint16, old, val uint8) {
+	d.checkWatchpoints(WATCH_WRITE, addr, old, val)
+}
+
+func (d *Debugger) checkWatchpoints(access uint8, addr uint16, old, val uint8) {
+	for i, w := range d.watchpoints {
+		if !d.watchMatches(w, access, addr, old, val) {
+			continue
+		}
+
+		var msg string
+		switch access {
+		case WATCH_READ:
+			msg = fmt.Sprintf("watchpoint %d: read %04X = %02X", i, addr, val)
+		case WATCH_WRITE:
+			msg = fmt.Sprintf("watchpoint %d: write %04X = %02X (was %02X)", i, addr, val, old)
+		case WATCH_EXEC:
+			msg = fmt.Sprintf("watchpoint %d: execute %04X", i, addr)
+		}
+		msg += fmt.Sprintf(" at PC %02X:%04X", d.gb.bankOf(d.pc), d.pc)
+		if label := d.gb.describe(d.pc); label != "" {
+			msg += " (" + label + ")"
+		}
+
+		if w.log {
+			fmt.Fprintln(d.out, msg)
+		} else if d.watchHit == "" {
+			d.watchHit = msg
+		}
+	}
+}
+
+func (d *Debugger) watchMatches(w Watchpoint, access uint8, addr uint16, old, val uint8) bool {
+	if addr < w.start || addr > w.end {
+		return false
+	}
+	if w.bank >= 0 && w.bank != d.gb.bankOf(addr) {
+		return false
+	}
+
+	switch w.kind {
+	case WATCH_CHANGE:
+		if access != WATCH_WRITE || old == val {
+			return false
+		}
+	case WATCH_EQUALS:
+		if access != WATCH_WRITE || val != w.value {
+			return false
+		}
+	default:
+		if w.kind&access == 0 {
+			return false
+		}
+	}
+
+	return w.cond == nil || d.evalCondition(*w.cond, old, val)
+}
+
+func (d *Debugger) evalCondition(cond Condition, old, val uint8) bool {
+	var lhs int
+	switch cond.lhs {
+	case "val":
+		lhs = int(val)
+	case "old":
+		lhs = int(old)
+	default:
+		lhs = d.getRegister(cond.lhs)
+	}
+
+	switch cond.op {
+	case "==":
+		return lhs == cond.rhs
+	case "!=":
+		return lhs != cond.rhs
+	case "<":
+		return lhs < cond.rhs
+	case ">":
+		return lhs > cond.rhs
+	case "<=":
+		return lhs <= cond.rhs
+	case ">=":
+		return lhs >= cond.rhs
+	}
+	return false
+}
+
+// parseWatchpoint parses "kind range [if lhs op value] [log]", where range is
+// a label or [bank:]addr, optionally followed by -end.
+func (d *Debugger) parseWatchpoint(args []string) (Watchpoint, error) {
+	w := Watchpoint{bank: -1}
+	if len(args) < 2 {
+		return w, fmt.Errorf("usage: watch r|w|rw|x|chg|=val [bank:]addr[-end] [if lhs op value] [log]")
+	}
+
+	if strings.HasPrefix(args[0], "=") {
+		val, err := parseHex(args[0][1:])
+		if err != nil {
+			return w, err
+		}
+		w.kind = WATCH_EQUALS
+		w.value = uint8(val)
+	} else if kind, ok := WATCH_KINDS[args[0]]; ok {
+		w.kind = kind
+	} else {
+		return w, fmt.Errorf("unknown watchpoint kind %q", args[0])
+	}
+
+	where, end := args[1], ""
+	if i := strings.Index(where, "-"); i >= 0 {
+		where, end = where[:i], where[i+1:]
+	}
+	start, err := d.gb.parseBreakpoint(where)
+	if err != nil {
+		return w, err
+	}
+	w.bank, w.start, w.end = start.bank, start.addr, start.addr
+	if end != "" {
+		e, err := d.gb.parseAddress(end)
+		if err != nil {
+			return w, err
+		}
+		if e < w.start {
+			return w, fmt.Errorf("range end %04X is before start %04X", e, w.start)
+		}
+		w.end = e
+	}
+
+	args = args[2:]
+	if len(args) > 0 && args[0] == "if" {
+		if len(args) < 4 {
+			return w, fmt.Errorf("usage: if lhs op value")
+		}
+		cond, err := d.parseCondition(args[1], args[2], args[3])
+		if err != nil {
+			return w, err
+		}
+		w.cond = &cond
+		args = args[4:]
+	}
+	if len(args) > 0 && args[0] == "log" {
+		w.log = true
+		args = args[1:]
+	}
+	if len(args) > 0 {
+		return w, fmt.Errorf("unexpected %q", args[0])
+	}
+	return w, nil
+}
+
+func (d *Debugger) parseCondition(lhs, op, rhs string) (Condition, error) {
+	cond := Condition{lhs: strings.ToLower(lhs), op: op}
+	if cond.lhs != "val" && cond.lhs != "old" && d.getRegister(cond.lhs) < 0 {
+		return cond, fmt.Errorf("unknown register %q", lhs)
+	}
+
+	valid := false
+	for _, o := range WATCH_OPERATORS {
+		valid = valid || o == op
+	}
+	if !valid {
+		return cond, fmt.Errorf("unknown operator %q", op)
+	}
+
+	val, err := parseHex(rhs)
+	if err != nil {
+		return cond, err
+	}
+	cond.rhs = val
+	return cond, nil
+}
+
+// updateHook only installs the MMU hook while there are read or write
+// watchpoints, so memory accesses stay cheap otherwise.
+func (d *Debugger) updateHook() {
+	d.gb.mmu.hook = nil
+	for _, w := range d.watchpoints {
+		if w.kind != WATCH_EXEC {
+			d.gb.mmu.hook = d
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package emu
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestWatchMatches(t *testing.T) {
+	gb := newTestGameBoy(t, nil, false)
+	d := NewDebugger(gb, strings.NewReader(""), ioutil.Discard)
+	// After the boot ROM, A is 01 and C is 13.
+	tests := []struct {
+		watch    string
+		access   uint8
+		addr     uint16
+		old, val uint8
+		want     bool
+	}{
+		{"r C000", WATCH_READ, 0xC000, 0, 0, true},
+		{"r C000", WATCH_WRITE, 0xC000, 0, 0, false},
+		{"r C000", WATCH_EXEC, 0xC000, 0, 0, false},
+		{"r C000", WATCH_READ, 0xC001, 0, 0, false},
+		{"w C000-C00F", WATCH_WRITE, 0xC00F, 0, 0, true},
+		{"w C000-C00F", WATCH_WRITE, 0xC010, 0, 0, false},
+		{"w C000-C00F", WATCH_READ, 0xC008, 0, 0, false},
+		{"rw C000", WATCH_READ, 0xC000, 0, 0, true},
+		{"rw C000", WATCH_WRITE, 0xC000, 0, 0, true},
+		{"x 0150", WATCH_EXEC, 0x0150, 0, 0, true},
+		{"x 0150", WATCH_READ, 0x0150, 0, 0, false},
+		{"chg C000", WATCH_WRITE, 0xC000, 1, 2, true},
+		{"chg C000", WATCH_WRITE, 0xC000, 2, 2, false},
+		{"chg C000", WATCH_READ, 0xC000, 1, 2, false},
+		{"=42 C000", WATCH_WRITE, 0xC000, 0, 0x42, true},
+		{"=42 C000", WATCH_WRITE, 0xC000, 0, 0x41, false},
+		{"=42 C000", WATCH_READ, 0xC000, 0x42, 0x42, false},
+		{"r 01:4000", WATCH_READ, 0x4000, 0, 0, true},
+		{"r 02:4000", WATCH_READ, 0x4000, 0, 0, false},
+		{"w C000 if val == 42", WATCH_WRITE, 0xC000, 0, 0x42, true},
+		{"w C000 if val == 42", WATCH_WRITE, 0xC000, 0, 0x41, false},
+		{"w C000 if old != 0", WATCH_WRITE, 0xC000, 0, 1, false},
+		{"w C000 if old != 0", WATCH_WRITE, 0xC000, 1, 1, true},
+		{"w C000 if val > 7F", WATCH_WRITE, 0xC000, 0, 0x80, true},
+		{"w C000 if val <= 7F", WATCH_WRITE, 0xC000, 0, 0x80, false},
+		{"w C000 if A >= 1", WATCH_WRITE, 0xC000, 0, 0, true},
+		{"w C000 if c < 13", WATCH_WRITE, 0xC000, 0, 0, false},
+	}
+	for _, test := range tests {
+		w, err := d.parseWatchpoint(strings.Fields(test.watch))
+		if err != nil {
+			t.Errorf("%s: %v", test.watch, err)
+			continue
+		}
+		if got := d.watchMatches(w, test.access, test.addr, test.old, test.val); got != test.want {
+			t.Errorf("%s: access %d at %04X (%02X to %02X) matches %v, want %v",
+				test.watch, test.access, test.addr, test.old, test.val, got, test.want)
+		}
+	}
+}
+
+func TestParseWatchpoint(t *testing.T) {
+	gb := newTestGameBoy(t, nil, false)
+	d := NewDebugger(gb, strings.NewReader(""), ioutil.Discard)
+	for _, s := range []string{"rw 01:4000-4FFF", "=FF FF80 log", "w C000 if val != 0 log"} {
+		w, err := d.parseWatchpoint(strings.Fields(s))
+		if err != nil {
+			t.Errorf("%s: %v", s, err)
+		} else if w.String() != s {
+			t.Errorf("%s is shown as %q", s, w.String())
+		}
+	}
+
+	for _, s := range []string{"r", "q C000", "r C010-C000", "r C000 if val", "r C000 if x == 1",
+		"r C000 if a ~ 1", "r C000 if a == zz", "r C000 log now"} {
+		if _, err := d.parseWatchpoint(strings.Fields(s)); err == nil {
+			t.Errorf("%s: parsed an invalid watchpoint", s)
+		}
+	}
+}
+
+func TestWatchpointStops(t *testing.T) {
+	gb := newTestGameBoy(t, []uint8{
+		0x3E, 0x42, // LD A,$42
+		0xEA, 0x00, 0xC0, // LD ($C000),A
+		0xFA, 0x00, 0xC0, // LD A,($C000)
+		0x18, 0xFE, // JR -2
+	}, false)
+	gb.mmu.WRAM0[0] = 0x11
+	var out bytes.Buffer
+	gb.debugger = NewDebugger(gb, strings.NewReader("wa w C000 log\nwa r C000\nwa x 0158 log\nc\nc\n"), &out)
+	gb.attachCallStack()
+	for i := 0; i < 10; i++ {
+		gb.step()
+	}
+
+	for _, want := range []string{
+		"watchpoint 0: write C000 = 42 (was 11) at PC 00:0152\n",
+		"Stopped: watchpoint 1: read C000 = 42 at PC 00:0155\n",
+		"watchpoint 2: execute 0158 at PC 00:0158\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("no %q in:\n%s", want, out.String())
+		}
+	}
+	if n := strings.Count(out.String(), "Stopped:"); n != 2 {
+		t.Errorf("stopped %d times, want 2:\n%s", n, out.String())
+	}
+}
@@ -0,0 +1,151 @@
+package frontend
+
+import (
+	"github.com/is386/GoBoy/emu"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+var (
+	KEYMAP = map[sdl.Keycode]uint8{
+		sdl.K_RETURN: emu.BUTTON_START,
+		sdl.K_RSHIFT: emu.BUTTON_SELECT,
+		sdl.K_w:      emu.BUTTON_UP,
+		sdl.K_s:      emu.BUTTON_DOWN,
+		sdl.K_a:      emu.BUTTON_LEFT,
+		sdl.K_d:      emu.BUTTON_RIGHT,
+		sdl.K_j:      emu.BUTTON_A,
+		sdl.K_k:      emu.BUTTON_B,
+	}
+
+	SLOTMAP = map[sdl.Keycode]int{
+		sdl.K_F1: 1,
+		sdl.K_F2: 2,
+		sdl.K_F3: 3,
+		sdl.K_F4: 4,
+	}
+)
+
+// Screen is an SDL window that implements both emu.VideoSink and
+// emu.InputSource.
+type Screen struct {
+	scale    int
+	win      *sdl.Window
+	sur      *sdl.Surface
+	id       uint32
+	pressed  uint8
+	quit     bool
+	saveSlot int
+	loadSlot int
+	rewind   bool
+}
+
+func NewScreen(scale int) *Screen {
+	if scale < 1 {
+		scale = 1
+	}
+
+	if err := sdl.Init(sdl.INIT_VIDEO); err != nil {
+		panic(err)
+	}
+
+	win, err := sdl.CreateWindow("", sdl.WINDOWPOS_UNDEFINED, sdl.WINDOWPOS_UNDEFINED,
+		int32(emu.WIDTH*scale), int32(emu.HEIGHT*scale), sdl.WINDOW_ALLOW_HIGHDPI)
+	if err != nil {
+		panic(err)
+	}
+
+	sur, err := win.GetSurface()
+	if err != nil {
+		panic(err)
+	}
+
+	sur.FillRect(nil, 0xF0F0F0)
+	win.UpdateSurface()
+
+	id, err := win.GetID()
+	if err != nil {
+		panic(err)
+	}
+
+	s := Screen{scale: scale, win: win, sur: sur, id: id}
+	return &s
+}
+
+func (s *Screen) Destroy() {
+	s.win.Destroy()
+	sdl.Quit()
+}
+
+func (s *Screen) SetTitle(title string) {
+	s.win.SetTitle(title)
+}
+
+func (s *Screen) DrawFrame(pixels []uint32) {
+	for y := 0; y < emu.HEIGHT; y++ {
+		for x := 0; x < emu.WIDTH; x++ {
+			s.drawPixel(int32(x), int32(y), pixels[y*emu.WIDTH+x])
+		}
+	}
+	s.win.UpdateSurface()
+}
+
+func (s *Screen) drawPixel(x int32, y int32, color uint32) {
+	s.sur.FillRect(&sdl.Rect{X: x * int32(s.scale), Y: y * int32(s.scale), W: int32(s.scale), H: int32(s.scale)}, color)
+}
+
+func (s *Screen) Poll() emu.Input {
+	for event := sdl.PollEvent(); event != nil; event = sdl.PollEvent() {
+		switch e := event.(type) {
+		case *sdl.QuitEvent:
+			s.quit = true
+		case *sdl.WindowEvent:
+			// SDL only sends a QuitEvent once every window is closed, so
+			// closing the main window quits and closing a view hides it.
+			if e.Event == sdl.WINDOWEVENT_CLOSE {
+				if e.WindowID == s.id {
+					s.quit = true
+				} else if win, err := sdl.GetWindowFromID(e.WindowID); err == nil {
+					win.Hide()
+				}
+			}
+		case *sdl.KeyboardEvent:
+			switch e.Type {
+			case sdl.KEYDOWN:
+				s.keyDown(e.Keysym.Sym, e.Keysym.Mod)
+			case sdl.KEYUP:
+				s.keyUp(e.Keysym.Sym)
+			}
+		}
+	}
+	in := emu.Input{Buttons: s.pressed, Quit: s.quit, SaveSlot: s.saveSlot, LoadSlot: s.loadSlot, Rewind: s.rewind}
+	s.saveSlot = 0
+	s.loadSlot = 0
+	return in
+}
+
+func (s *Screen) keyDown(key sdl.Keycode, mod uint16) {
+	if key == sdl.K_ESCAPE {
+		s.quit = true
+		return
+	}
+	if key == sdl.K_BACKSPACE {
+		s.rewind = true
+		return
+	}
+	if slot, ok := SLOTMAP[key]; ok {
+		if mod&sdl.KMOD_SHIFT != 0 {
+			s.saveSlot = slot
+		} else {
+			s.loadSlot = slot
+		}
+		return
+	}
+	s.pressed |= KEYMAP[key]
+}
+
+func (s *Screen) keyUp(key sdl.Keycode) {
+	if key == sdl.K_BACKSPACE {
+		s.rewind = false
+	}
+	s.pressed &^= KEYMAP[key]
+}
@@ -0,0 +1,58 @@
+package frontend
+
+import (
+	"time"
+
+	"github.com/hajimehoshi/oto"
+	"github.com/is386/GoBoy/emu/apu"
+)
+
+var (
+	FPS       = 120
+	SAMPLES   = 8192
+	FRAMETIME = time.Second / time.Duration(FPS)
+)
+
+// Speaker plays samples through oto. It implements emu.AudioSink.
+type Speaker struct {
+	player *oto.Player
+	buffer chan [2]uint8
+}
+
+func NewSpeaker() *Speaker {
+	s := &Speaker{buffer: make(chan [2]uint8, SAMPLES)}
+
+	ctx, err := oto.NewContext(apu.SAMPLE_RATE, 2, 1, apu.SAMPLE_RATE/FPS)
+	if err != nil {
+		panic(err)
+	}
+
+	s.player = ctx.NewPlayer()
+	s.startSoundRoutine()
+	return s
+}
+
+func (s *Speaker) PlaySamples(samples []uint8) {
+	for i := 0; i+1 < len(samples); i += 2 {
+		s.buffer <- [2]uint8{samples[i], samples[i+1]}
+	}
+}
+
+func (s *Speaker) startSoundRoutine() {
+	ticker := time.NewTicker(FRAMETIME)
+	go func() {
+		var reading [2]byte
+		for range ticker.C {
+			fbLen := len(s.buffer)
+			buffer := make([]byte, fbLen*2)
+			for i := 0; i < fbLen*2; i += 2 {
+				reading = <-s.buffer
+				if reading[0] == 0 && reading[1] == 0 {
+					continue
+				}
+				buffer[i], buffer[i+1] = reading[0], reading[1]
+			}
+			s.player.Write(buffer)
+		}
+	}()
+}
@@ -0,0 +1,47 @@
+package frontend
+
+import (
+	"image"
+
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// ViewWindow is an extra SDL window that implements emu.ImageSink for the
+// debug views. It must be created after the main Screen.
+type ViewWindow struct {
+	scale int
+	win   *sdl.Window
+	sur   *sdl.Surface
+}
+
+func NewViewWindow(title string, width, height, scale int) *ViewWindow {
+	if scale < 1 {
+		scale = 1
+	}
+
+	win, err := sdl.CreateWindow(title, sdl.WINDOWPOS_UNDEFINED, sdl.WINDOWPOS_UNDEFINED,
+		int32(width*scale), int32(height*scale), sdl.WINDOW_ALLOW_HIGHDPI)
+	if err != nil {
+		panic(err)
+	}
+
+	sur, err := win.GetSurface()
+	if err != nil {
+		panic(err)
+	}
+
+	v := ViewWindow{scale: scale, win: win, sur: sur}
+	return &v
+}
+
+func (v *ViewWindow) DrawImage(img *image.RGBA) {
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			i := img.PixOffset(x, y)
+			color := uint32(img.Pix[i])<<16 | uint32(img.Pix[i+1])<<8 | uint32(img.Pix[i+2])
+			v.sur.FillRect(&sdl.Rect{X: int32(x * v.scale), Y: int32(y * v.scale), W: int32(v.scale), H: int32(v.scale)}, color)
+		}
+	}
+	v.win.UpdateSurface()
+}
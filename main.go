@@ -6,19 +6,45 @@ package main
 // - Figure out minor HDMA bugs
 // - Figure out why Oracle of Seasons doesn't work
 // - Super GameBoy
-// - Save States
-// - Rewind
 
 import (
 	"fmt"
 	"os"
+	"os/signal"
+	"strings"
 
 	"github.com/akamensky/argparse"
 	"github.com/is386/GoBoy/emu"
+	"github.com/is386/GoBoy/frontend"
 	"github.com/sqweek/dialog"
 )
 
-func parseArgs() (string, int, bool) {
+type options struct {
+	bootPath       string
+	scale          int
+	debug          bool
+	rewindSeconds  int
+	rewindInterval int
+	recordPath     string
+	playPath       string
+	movieSlot      int
+	tracePath      string
+	traceBinary    bool
+	traceStart     string
+	traceStop      string
+	traceLabels    bool
+	traceDisasm    bool
+	symPath        string
+	gdbPort        int
+	views          string
+	profilePath    string
+	cdlPath        string
+	linkHost       int
+	linkJoin       int
+	linkBGB        bool
+}
+
+func parseArgs() options {
 	parser := argparse.NewParser("GameFella", "A simple GameBoy emulator written in Go.")
 
 	bootFlag := parser.String("b", "boot",
@@ -38,7 +64,140 @@ func parseArgs() (string, int, bool) {
 	debugFlag := parser.Flag("d", "debug",
 		&argparse.Options{
 			Required: false,
-			Help:     "Turns on debugging mode",
+			Help:     "Starts the interactive debugger, Ctrl-C breaks into it",
+			Default:  false,
+		})
+
+	rewindFlag := parser.Int("r", "rewind",
+		&argparse.Options{
+			Required: false,
+			Help:     "Seconds of rewind history to keep, 0 to disable",
+			Default:  emu.REWIND_SECONDS,
+		})
+
+	rewindIntervalFlag := parser.Int("", "rewind-interval",
+		&argparse.Options{
+			Required: false,
+			Help:     "Frames between rewind snapshots",
+			Default:  emu.REWIND_INTERVAL,
+		})
+
+	recordFlag := parser.String("", "record",
+		&argparse.Options{
+			Required: false,
+			Help:     "Record the joypad to a movie file",
+			Default:  "",
+		})
+
+	playFlag := parser.String("", "play",
+		&argparse.Options{
+			Required: false,
+			Help:     "Play back a movie file",
+			Default:  "",
+		})
+
+	movieSlotFlag := parser.Int("", "movie-slot",
+		&argparse.Options{
+			Required: false,
+			Help:     "Start the recorded movie from a save state slot instead of power-on",
+			Default:  0,
+		})
+
+	traceFlag := parser.String("", "trace",
+		&argparse.Options{
+			Required: false,
+			Help:     "Log every instruction to a file in the Gameboy Doctor format",
+			Default:  "",
+		})
+
+	traceBinaryFlag := parser.Flag("", "trace-binary",
+		&argparse.Options{
+			Required: false,
+			Help:     "Write the trace in a compact binary format",
+			Default:  false,
+		})
+
+	traceStartFlag := parser.String("", "trace-start",
+		&argparse.Options{
+			Required: false,
+			Help:     "Start tracing at a label, [bank:]addr or frame:n",
+			Default:  "",
+		})
+
+	traceStopFlag := parser.String("", "trace-stop",
+		&argparse.Options{
+			Required: false,
+			Help:     "Stop tracing at a label, [bank:]addr or frame:n",
+			Default:  "",
+		})
+
+	traceLabelsFlag := parser.Flag("", "trace-labels",
+		&argparse.Options{
+			Required: false,
+			Help:     "Append the closest label to each trace line",
+			Default:  false,
+		})
+
+	traceDisasmFlag := parser.Flag("", "trace-disasm",
+		&argparse.Options{
+			Required: false,
+			Help:     "Append the disassembled instruction to each trace line",
+			Default:  false,
+		})
+
+	symFlag := parser.String("", "sym",
+		&argparse.Options{
+			Required: false,
+			Help:     "Path to an RGBDS .sym file, found next to the ROM by default",
+			Default:  "",
+		})
+
+	gdbFlag := parser.Int("", "gdb",
+		&argparse.Options{
+			Required: false,
+			Help:     "Listen for a GDB remote debugger on this localhost port, 0 to disable",
+			Default:  0,
+		})
+
+	viewsFlag := parser.String("", "views",
+		&argparse.Options{
+			Required: false,
+			Help:     "Open debug windows, a comma separated list of tiles, map0, map1, oam and palettes, or all",
+			Default:  "",
+		})
+
+	profileFlag := parser.String("", "profile",
+		&argparse.Options{
+			Required: false,
+			Help:     "Profile the game and write <prefix>.txt and a pprof <prefix>.pb.gz on exit",
+			Default:  "",
+		})
+
+	cdlFlag := parser.String("", "cdl",
+		&argparse.Options{
+			Required: false,
+			Help:     "Log the bytes used as code, data and graphics to a BizHawk code/data log, adding to the log already in it",
+			Default:  "",
+		})
+
+	linkHostFlag := parser.Int("", "link-host",
+		&argparse.Options{
+			Required: false,
+			Help:     "Wait for another GameFella to plug a link cable in on this localhost port, 0 to disable",
+			Default:  0,
+		})
+
+	linkJoinFlag := parser.Int("", "link-join",
+		&argparse.Options{
+			Required: false,
+			Help:     "Plug a link cable into the GameFella hosting on this localhost port, 0 to disable",
+			Default:  0,
+		})
+
+	linkBGBFlag := parser.Flag("", "link-bgb",
+		&argparse.Options{
+			Required: false,
+			Help:     "Speak the BGB link protocol 1.4 on the link cable, to link with BGB and compatible emulators and tools",
 			Default:  false,
 		})
 
@@ -48,15 +207,165 @@ func parseArgs() (string, int, bool) {
 		os.Exit(0)
 	}
 
-	return *bootFlag, *scaleFlag, *debugFlag
+	return options{
+		bootPath:       *bootFlag,
+		scale:          *scaleFlag,
+		debug:          *debugFlag,
+		rewindSeconds:  *rewindFlag,
+		rewindInterval: *rewindIntervalFlag,
+		recordPath:     *recordFlag,
+		playPath:       *playFlag,
+		movieSlot:      *movieSlotFlag,
+		tracePath:      *traceFlag,
+		traceBinary:    *traceBinaryFlag,
+		traceStart:     *traceStartFlag,
+		traceStop:      *traceStopFlag,
+		traceLabels:    *traceLabelsFlag,
+		traceDisasm:    *traceDisasmFlag,
+		symPath:        *symFlag,
+		gdbPort:        *gdbFlag,
+		views:          *viewsFlag,
+		profilePath:    *profileFlag,
+		cdlPath:        *cdlFlag,
+		linkHost:       *linkHostFlag,
+		linkJoin:       *linkJoinFlag,
+		linkBGB:        *linkBGBFlag,
+	}
 }
 
 func main() {
-	bootPath, scale, debug := parseArgs()
+	if len(os.Args) > 1 && os.Args[1] == "disasm" {
+		runDisasm(os.Args[1:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "cdl" {
+		runCDL(os.Args[1:])
+		return
+	}
+
+	opts := parseArgs()
 	rom, err := dialog.File().Filter("GB/GBC Rom File", "gb", "gbc").Load()
 	if err != nil {
 		panic(err)
 	}
-	gb := emu.NewGameBoy(rom, bootPath, scale, debug)
+	screen := frontend.NewScreen(opts.scale)
+	gb := emu.NewGameBoy(rom, opts.bootPath, opts.debug, screen, frontend.NewSpeaker(), screen)
+	gb.EnableRewind(opts.rewindSeconds, opts.rewindInterval)
+
+	if opts.symPath != "" {
+		if err := gb.LoadSymbols(opts.symPath); err != nil {
+			fmt.Println(err)
+			os.Exit(0)
+		}
+	}
+
+	if opts.debug {
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, os.Interrupt)
+		go func() {
+			for range sigs {
+				gb.BreakIntoDebugger()
+			}
+		}()
+	}
+
+	if opts.playPath != "" {
+		if err := gb.PlayMovie(opts.playPath); err != nil {
+			fmt.Println(err)
+			os.Exit(0)
+		}
+	} else if opts.recordPath != "" {
+		if opts.movieSlot > 0 {
+			if err := gb.LoadSlot(opts.movieSlot); err != nil {
+				fmt.Println(err)
+				os.Exit(0)
+			}
+		}
+		if err := gb.RecordMovie(opts.recordPath, opts.movieSlot > 0); err != nil {
+			fmt.Println(err)
+			os.Exit(0)
+		}
+	}
+
+	if opts.views != "" {
+		openViews(gb, opts)
+	}
+
+	if opts.gdbPort > 0 {
+		if err := gb.ServeGDB(fmt.Sprintf("localhost:%d", opts.gdbPort)); err != nil {
+			fmt.Println(err)
+			os.Exit(0)
+		}
+	}
+
+	if opts.profilePath != "" {
+		gb.StartProfile(opts.profilePath)
+	}
+
+	if opts.cdlPath != "" {
+		if err := gb.StartCDL(opts.cdlPath); err != nil {
+			fmt.Println(err)
+			os.Exit(0)
+		}
+	}
+
+	protocol := emu.LINK_GAMEFELLA
+	if opts.linkBGB {
+		protocol = emu.LINK_BGB
+	}
+	if opts.linkHost > 0 {
+		if err := gb.HostLink(fmt.Sprintf("localhost:%d", opts.linkHost), protocol); err != nil {
+			fmt.Println(err)
+			os.Exit(0)
+		}
+	} else if opts.linkJoin > 0 {
+		if err := gb.JoinLink(fmt.Sprintf("localhost:%d", opts.linkJoin), protocol); err != nil {
+			fmt.Println(err)
+			os.Exit(0)
+		}
+	}
+
+	if opts.tracePath != "" {
+		startTrace(gb, opts)
+	}
+
 	gb.Run()
 }
+
+func startTrace(gb *emu.GameBoy, opts options) {
+	trace := emu.TraceOptions{Binary: opts.traceBinary, Labels: opts.traceLabels,
+		Disasm: opts.traceDisasm}
+	var err error
+	if opts.traceStart != "" {
+		if trace.Start, err = gb.ParseTraceTrigger(opts.traceStart); err != nil {
+			fmt.Println(err)
+			os.Exit(0)
+		}
+	}
+	if opts.traceStop != "" {
+		if trace.Stop, err = gb.ParseTraceTrigger(opts.traceStop); err != nil {
+			fmt.Println(err)
+			os.Exit(0)
+		}
+	}
+	if err := gb.StartTrace(opts.tracePath, trace); err != nil {
+		fmt.Println(err)
+		os.Exit(0)
+	}
+}
+
+func openViews(gb *emu.GameBoy, opts options) {
+	names := strings.Split(opts.views, ",")
+	if opts.views == "all" {
+		names = emu.VIEWS
+	}
+	for _, name := range names {
+		img, err := gb.RenderView(name)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(0)
+		}
+		win := frontend.NewViewWindow(name, img.Bounds().Dx(), img.Bounds().Dy(), opts.scale)
+		gb.AttachView(name, win)
+	}
+}